@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alexproskurov/snapfolio/controllers"
@@ -27,7 +34,39 @@ type config struct {
 	} `mapstructure:"csrf"`
 	Server struct {
 		Address string
+		// TrustedProxies are the IP addresses or CIDR ranges of the
+		// reverse proxies whose forwarding headers are trusted.
+		TrustedProxies []string
 	} `mapstructure:"server"`
+	Cookie struct {
+		// Key signs cookies whose contents aren't stored in the database,
+		// such as the ones that unlock password-protected galleries. If not
+		// set, the CSRF key is used.
+		Key string
+	} `mapstructure:"cookie"`
+	Storage struct {
+		// Backend is either "local" (the default) or "s3".
+		Backend string
+		Dir     string
+	} `mapstructure:"storage"`
+	S3   models.S3Config `mapstructure:"s3"`
+	Jobs struct {
+		Workers int
+	} `mapstructure:"jobs"`
+	Session struct {
+		Lifetime         time.Duration
+		IdleTimeout      time.Duration
+		RotationInterval time.Duration
+	} `mapstructure:"session"`
+	Trash struct {
+		// Retention is how long deleted galleries and images are kept
+		// before they are purged.
+		Retention time.Duration
+	} `mapstructure:"trash"`
+	// SweepInterval is how often expired sessions, password resets and other
+	// short-lived tokens are deleted from the database, and the trash is
+	// purged.
+	SweepInterval time.Duration `mapstructure:"sweepinterval"`
 }
 
 func loadEnvConfig(path string) (config, error) {
@@ -57,50 +96,269 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	err = run(cfg)
+
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	switch command {
+	case "serve":
+		err = run(cfg)
+	case "reconcile":
+		err = reconcile(cfg)
+	case "gc":
+		err = gc(cfg)
+	case "strip-metadata":
+		err = stripMetadata(cfg)
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
 	if err != nil {
 		panic(err)
 	}
 }
 
-func run(cfg config) error {
-	// Setup the database.
+// openDB opens a connection to the database and brings its schema up to date.
+func openDB(cfg config) (*sql.DB, error) {
 	db, err := models.Open(cfg.PSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = models.MigrateFS(db, migrations.FS, ".")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// newStorage creates the storage backend selected in the config.
+func newStorage(cfg config) (models.Storage, error) {
+	switch cfg.Storage.Backend {
+	case "", "local":
+		return &models.LocalStorage{
+			Dir: cfg.Storage.Dir,
+		}, nil
+	case "s3":
+		return models.NewS3Storage(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+// reconcile imports images that exist on disk but are missing from the
+// images table.
+func reconcile(cfg config) error {
+	db, err := openDB(cfg)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	err = db.Ping()
+	storage, err := newStorage(cfg)
 	if err != nil {
 		return err
 	}
+	imageService := &models.ImageService{
+		DB:      db,
+		Storage: storage,
+	}
+	imported, err := imageService.Reconcile()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d images.\n", imported)
 
-	err = models.MigrateFS(db, migrations.FS, ".")
+	return nil
+}
+
+// stripMetadata strips the metadata of stored images again, for images
+// stored before the current rules for stripping existed.
+func stripMetadata(cfg config) error {
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	storage, err := newStorage(cfg)
+	if err != nil {
+		return err
+	}
+	imageService := &models.ImageService{
+		DB:      db,
+		Storage: storage,
+	}
+	stripped, err := imageService.StripStoredMetadata()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Stripped metadata from %d images.\n", stripped)
+
+	return nil
+}
+
+// gc reports the stored files that no gallery or image refers to. It doesn't
+// delete anything.
+func gc(cfg config) error {
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	storage, err := newStorage(cfg)
+	if err != nil {
+		return err
+	}
+	imageService := &models.ImageService{
+		DB:      db,
+		Storage: storage,
+	}
+	report, err := imageService.FindOrphans()
+	if err != nil {
+		return err
+	}
+	for _, gallery := range report.Galleries {
+		fmt.Printf("%s\t%d files, %d bytes, gallery no longer exists\n",
+			gallery.Prefix, gallery.Objects, gallery.Size)
+	}
+	for _, obj := range report.Files {
+		fmt.Printf("%s\t%d bytes, not referenced by any image\n", obj.Key, obj.Size)
+	}
+	fmt.Printf("Found %d orphaned gallery directories and %d orphaned files.\n",
+		len(report.Galleries), len(report.Files))
+
+	return nil
+}
+
+// every calls fn immediately and then once per interval until ctx is
+// canceled. Errors are logged. If interval is not set, it defaults to an hour.
+func every(ctx context.Context, interval time.Duration, fn func() error) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := fn()
+		if err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func run(cfg config) error {
+	// Setup the database.
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	storage, err := newStorage(cfg)
 	if err != nil {
 		return err
 	}
 
 	// Setup services.
+	jobService := &models.JobService{
+		DB:      db,
+		Workers: cfg.Jobs.Workers,
+	}
 	userService := &models.UserService{
 		DB: db,
 	}
 	sessionService := &models.SessionService{
-		DB: db,
+		DB:               db,
+		Lifetime:         cfg.Session.Lifetime,
+		IdleTimeout:      cfg.Session.IdleTimeout,
+		RotationInterval: cfg.Session.RotationInterval,
 	}
 	pwResetService := &models.PasswordResetService{
 		DB: db,
 	}
+	emailVerificationService := &models.EmailVerificationService{
+		DB: db,
+	}
+	twoFactorService := &models.TwoFactorService{
+		DB: db,
+	}
+	accessTokenService := &models.AccessTokenService{
+		DB: db,
+	}
+	shareLinkService := &models.ShareLinkService{
+		DB: db,
+	}
 	emailService := models.NewEmailService(cfg.SMTP)
+	emailService.Jobs = jobService
+	emailService.PasswordResets = pwResetService
+	emailService.EmailVerifications = emailVerificationService
 	galleryService := &models.GalleryService{
+		DB:      db,
+		Storage: storage,
+		Jobs:    jobService,
+	}
+	imageService := &models.ImageService{
+		DB:      db,
+		Storage: storage,
+		Jobs:    jobService,
+	}
+	searchService := &models.SearchService{
 		DB: db,
 	}
+	trashService := &models.TrashService{
+		DB:             db,
+		GalleryService: galleryService,
+		ImageService:   imageService,
+		Retention:      cfg.Trash.Retention,
+	}
+
+	// Start the background workers.
+	jobService.Handle(models.JobSendEmail, emailService.HandleSendEmail)
+	jobService.Handle(models.JobSendPasswordReset, emailService.HandleSendPasswordReset)
+	jobService.Handle(models.JobSendEmailVerification, emailService.HandleSendEmailVerification)
+	jobService.Handle(models.JobProcessImage, imageService.HandleProcessImage)
+	jobService.Handle(models.JobDeleteGalleryFiles, galleryService.HandleDeleteGalleryFiles)
+	jobService.Handle(models.JobDeleteFiles, imageService.HandleDeleteFiles)
+	// ctx is canceled when the server is asked to stop.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	jobsDone := make(chan struct{})
+	go func() {
+		jobService.Run(ctx)
+		close(jobsDone)
+	}()
+	go every(ctx, cfg.SweepInterval, sessionService.DeleteExpired)
+	go every(ctx, cfg.SweepInterval, pwResetService.DeleteExpired)
+	go every(ctx, cfg.SweepInterval, emailVerificationService.DeleteExpired)
+	go every(ctx, cfg.SweepInterval, twoFactorService.DeleteExpired)
+	go every(ctx, cfg.SweepInterval, trashService.Purge)
 
 	// Setup middleware.
 	umw := controllers.UserMiddleware{
 		SessionService: sessionService,
 	}
 
+	realIPMw, err := controllers.RealIP(cfg.Server.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
 	csrfMw := csrf.Protect(
 		[]byte(cfg.CSRF.Key),
 		csrf.Secure(cfg.CSRF.Secure),
@@ -109,10 +367,13 @@ func run(cfg config) error {
 
 	// Setup controllers.
 	userC := controllers.User{
-		UserService:          userService,
-		SessionService:       sessionService,
-		PasswordResetService: pwResetService,
-		EmailService:         emailService,
+		UserService:              userService,
+		SessionService:           sessionService,
+		PasswordResetService:     pwResetService,
+		EmailVerificationService: emailVerificationService,
+		EmailService:             emailService,
+		TwoFactorService:         twoFactorService,
+		AccessTokenService:       accessTokenService,
 	}
 	userC.Templates.New = views.Must(views.ParseFS(
 		templates.FS,
@@ -138,9 +399,41 @@ func run(cfg config) error {
 		templates.FS,
 		"tailwind.gohtml", "change-email.gohtml",
 	))
+	userC.Templates.VerifyEmail = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "verify-email.gohtml",
+	))
+	userC.Templates.ConfirmEmail = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "confirm-email.gohtml",
+	))
+	userC.Templates.Devices = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "devices.gohtml",
+	))
+	userC.Templates.TwoFactor = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "signin-2fa.gohtml",
+	))
+	userC.Templates.TwoFactorSettings = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "two-factor.gohtml",
+	))
+	userC.Templates.AccessTokens = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "access-tokens.gohtml",
+	))
 
+	cookieKey := cfg.Cookie.Key
+	if cookieKey == "" {
+		cookieKey = cfg.CSRF.Key
+	}
 	galleryC := controllers.Gallery{
-		GalleryService: galleryService,
+		GalleryService:   galleryService,
+		ImageService:     imageService,
+		ShareLinkService: shareLinkService,
+		TrashService:     trashService,
+		UnlockKey:        []byte(cookieKey),
 	}
 	galleryC.Templates.New = views.Must(views.ParseFS(
 		templates.FS,
@@ -158,64 +451,178 @@ func run(cfg config) error {
 		templates.FS,
 		"tailwind.gohtml", "galleries/show.gohtml",
 	))
+	galleryC.Templates.Unlock = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "galleries/unlock.gohtml",
+	))
+	galleryC.Templates.Import = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "galleries/import.gohtml",
+	))
+	galleryC.Templates.ImageInfo = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "galleries/image.gohtml",
+	))
+	galleryC.Templates.Tag = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "galleries/tag.gohtml",
+	))
+	galleryC.Templates.Trash = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "galleries/trash.gohtml",
+	))
+
+	searchC := controllers.Search{
+		SearchService: searchService,
+	}
+	searchC.Templates.Results = views.Must(views.ParseFS(
+		templates.FS,
+		"tailwind.gohtml", "search.gohtml",
+	))
+
+	apiC := controllers.API{
+		AccessTokenService: accessTokenService,
+		GalleryService:     galleryService,
+		ImageService:       imageService,
+		SearchService:      searchService,
+	}
 
 	// Setup router and routes.
 	r := chi.NewRouter()
-	r.Use(csrfMw)
-	r.Use(umw.SetUser)
+	r.Use(realIPMw)
 	r.Use(middleware.Logger)
 	r.Use(httprate.LimitAll(100, 1*time.Minute))
-	r.Get("/", controllers.StaticHandler(views.Must(views.ParseFS(
-		templates.FS,
-		"tailwind.gohtml", "home.gohtml",
-	))))
-	r.Get("/contact", controllers.StaticHandler(views.Must(views.ParseFS(
-		templates.FS,
-		"tailwind.gohtml", "contact.gohtml",
-	))))
-	r.Get("/faq", controllers.FAQ(views.Must(views.ParseFS(
-		templates.FS,
-		"tailwind.gohtml", "faq.gohtml",
-	))))
-
-	//users
-	r.Get("/signup", userC.New)
-	r.Get("/signin", userC.SignIn)
-	r.Post("/signin", userC.ProcessSignIn)
-	r.Post("/signout", userC.ProcessSignOut)
-	r.Get("/forgot-pw", userC.ForgotPassword)
-	r.Post("/forgot-pw", userC.ProcessForgotPassword)
-	r.Get("/reset-pw", userC.ResetPassword)
-	r.Post("/reset-pw", userC.ProcessResetPassword)
-	r.Route("/users", func(r chi.Router) {
-		r.Post("/", userC.Create)
+
+	//api
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(apiC.RequireToken)
+		r.Get("/user", apiC.CurrentUser)
+		r.Get("/galleries", apiC.Galleries)
+		r.Post("/galleries", apiC.CreateGallery)
+		r.Get("/galleries/{id}", apiC.Gallery)
+		r.Patch("/galleries/{id}", apiC.UpdateGallery)
+		r.Delete("/galleries/{id}", apiC.DeleteGallery)
+		r.Get("/galleries/{id}/images", apiC.Images)
+		r.Post("/galleries/{id}/images", apiC.UploadImages)
+		r.Post("/galleries/{id}/images/import", apiC.ImportImages)
+		r.Patch("/galleries/{id}/images/{imageID}", apiC.UpdateImage)
+		r.Delete("/galleries/{id}/images/{imageID}", apiC.DeleteImage)
+		r.Get("/search", apiC.Search)
+	})
+
+	// Pages use session cookies, so they are protected against CSRF. The API
+	// authenticates with access tokens instead and doesn't need it.
+	r.Group(func(r chi.Router) {
+		r.Use(csrfMw)
+		r.Use(umw.SetUser)
+		r.Get("/", controllers.StaticHandler(views.Must(views.ParseFS(
+			templates.FS,
+			"tailwind.gohtml", "home.gohtml",
+		))))
+		r.Get("/contact", controllers.StaticHandler(views.Must(views.ParseFS(
+			templates.FS,
+			"tailwind.gohtml", "contact.gohtml",
+		))))
+		r.Get("/faq", controllers.FAQ(views.Must(views.ParseFS(
+			templates.FS,
+			"tailwind.gohtml", "faq.gohtml",
+		))))
+
+		//users
+		r.Get("/signup", userC.New)
+		r.Get("/signin", userC.SignIn)
+		r.Post("/signin", userC.ProcessSignIn)
+		r.Get("/signin/2fa", userC.TwoFactor)
+		r.Post("/signin/2fa", userC.ProcessTwoFactor)
+		r.Post("/signout", userC.ProcessSignOut)
+		r.Get("/forgot-pw", userC.ForgotPassword)
+		r.Post("/forgot-pw", userC.ProcessForgotPassword)
+		r.Get("/reset-pw", userC.ResetPassword)
+		r.Post("/reset-pw", userC.ProcessResetPassword)
+		r.Get("/verify-email", userC.ConfirmEmail)
+		r.Post("/verify-email", userC.ProcessConfirmEmail)
+		r.Route("/users", func(r chi.Router) {
+			r.Post("/", userC.Create)
+			r.Group(func(r chi.Router) {
+				r.Use(umw.RequireUser)
+				r.Get("/me", userC.CurrentUser)
+				r.Get("/edit", userC.ChangeEmail)
+				r.Post("/edit", userC.ProcessChangeEmail)
+				r.Post("/verify-email", userC.ProcessResendVerification)
+				r.Get("/sessions", userC.Devices)
+				r.Post("/sessions/delete", userC.ProcessSignOutEverywhere)
+				r.Post("/sessions/{id}/delete", userC.ProcessRevokeDevice)
+				r.Get("/2fa", userC.TwoFactorSettings)
+				r.Post("/2fa/setup", userC.ProcessTwoFactorSetup)
+				r.Post("/2fa/enable", userC.ProcessTwoFactorEnable)
+				r.Post("/2fa/disable", userC.ProcessTwoFactorDisable)
+				r.Get("/tokens", userC.AccessTokens)
+				r.Post("/tokens", userC.ProcessCreateAccessToken)
+				r.Post("/tokens/{id}/delete", userC.ProcessRevokeAccessToken)
+			})
+		})
+
+		//galleries
+		r.Route("/galleries", func(r chi.Router) {
+			r.Get("/{id}", galleryC.Show)
+			r.Get("/{id}/images/{imageID}", galleryC.Image)
+			r.Get("/{id}/images/{imageID}/info", galleryC.ImageInfo)
+			r.Get("/{id}/download", galleryC.Download)
+			r.Get("/{id}/unlock", galleryC.Unlock)
+			// Limit how many passwords a visitor can guess per gallery.
+			r.With(httprate.Limit(10, 15*time.Minute,
+				httprate.WithKeyFuncs(httprate.KeyByIP, httprate.KeyByEndpoint),
+				httprate.WithLimitHandler(func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, "Too many attempts. Please try again later.", http.StatusTooManyRequests)
+				}),
+			)).Post("/{id}/unlock", galleryC.ProcessUnlock)
+			r.Group(func(r chi.Router) {
+				r.Use(umw.RequireUser)
+				r.Get("/", galleryC.Index)
+				r.Get("/new", galleryC.New)
+				r.Post("/", galleryC.Create)
+				r.Get("/{id}/edit", galleryC.Edit)
+				r.Post("/{id}", galleryC.Update)
+				r.Post("/{id}/delete", galleryC.Delete)
+				r.Post("/{id}/visibility", galleryC.UpdateVisibility)
+				r.Post("/{id}/metadata-policy", galleryC.UpdateMetadataPolicy)
+				r.Post("/{id}/share-links", galleryC.CreateShareLink)
+				r.Post("/{id}/share-links/{linkID}/revoke", galleryC.RevokeShareLink)
+				r.Post("/{id}/images", galleryC.UploadImage)
+				r.Post("/{id}/images/import", galleryC.ImportImages)
+				r.Post("/{id}/images/reorder", galleryC.ReorderImages)
+				r.Post("/{id}/images/{imageID}", galleryC.UpdateImage)
+				r.Post("/{id}/images/{imageID}/cover", galleryC.SetCover)
+				r.Post("/{id}/images/{imageID}/delete", galleryC.DeleteImage)
+			})
+		})
+
+		//search
+		r.Get("/search", searchC.Results)
+
+		//tags
 		r.Group(func(r chi.Router) {
 			r.Use(umw.RequireUser)
-			r.Get("/me", userC.CurrentUser)
-			r.Get("/edit", userC.ChangeEmail)
-			r.Post("/edit", userC.ProcessChangeEmail)
+			r.Get("/tags/{tag}", galleryC.Tag)
 		})
-	})
 
-	//galleries
-	r.Route("/galleries", func(r chi.Router) {
-		r.Get("/{id}", galleryC.Show)
-		r.Get("/{id}/images/{filename}", galleryC.Image)
-		r.Group(func(r chi.Router) {
+		//trash
+		r.Route("/trash", func(r chi.Router) {
 			r.Use(umw.RequireUser)
-			r.Get("/", galleryC.Index)
-			r.Get("/new", galleryC.New)
-			r.Post("/", galleryC.Create)
-			r.Get("/{id}/edit", galleryC.Edit)
-			r.Post("/{id}", galleryC.Update)
-			r.Post("/{id}/delete", galleryC.Delete)
-			r.Post("/{id}/images", galleryC.UploadImage)
-			r.Post("/{id}/images/{filename}/delete", galleryC.DeleteImage)
+			r.Get("/", galleryC.Trash)
+			r.Post("/galleries/{id}/restore", galleryC.RestoreGallery)
+			r.Post("/images/{id}/restore", galleryC.RestoreImage)
 		})
-	})
 
-	assetsHandler := http.FileServer(http.Dir("assets"))
-	r.Get("/assets/*", http.StripPrefix("/assets", assetsHandler).ServeHTTP)
+		//share links
+		r.Get("/s/{token}", galleryC.Shared)
+		r.Get("/s/{token}/images/{imageID}", galleryC.SharedImage)
+		r.Get("/s/{token}/images/{imageID}/info", galleryC.SharedImageInfo)
+		r.Get("/s/{token}/download", galleryC.SharedDownload)
+
+		assetsHandler := http.FileServer(http.Dir("assets"))
+		r.Get("/assets/*", http.StripPrefix("/assets", assetsHandler).ServeHTTP)
+	})
 
 	//other
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
@@ -223,6 +630,27 @@ func run(cfg config) error {
 	})
 
 	// Start the server.
+	server := &http.Server{
+		Addr:    cfg.Server.Address,
+		Handler: r,
+	}
+	go func() {
+		<-ctx.Done()
+		err := server.Shutdown(context.Background())
+		if err != nil {
+			log.Printf("shutting down the server: %v", err)
+		}
+	}()
+
 	fmt.Printf("Starting the server on %s...\n", cfg.Server.Address)
-	return http.ListenAndServe(cfg.Server.Address, r)
+	err = server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	// Wait for the jobs in progress to finish, so that they aren't left
+	// running until they are requeued as stale.
+	stop()
+	<-jobsDone
+
+	return err
 }
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexproskurov/snapfolio/context"
+	"github.com/alexproskurov/snapfolio/errors"
+	"github.com/alexproskurov/snapfolio/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type accessTokensData struct {
+	Tokens []accessTokenData
+	// NewToken is only set right after a token has been created, as it
+	// can't be shown again later.
+	NewToken string
+	Name     string
+}
+
+type accessTokenData struct {
+	ID         int
+	Name       string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func (u User) AccessTokens(w http.ResponseWriter, r *http.Request) {
+	var data accessTokensData
+	err := u.addAccessTokens(&data, context.User(r.Context()).ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	u.Templates.AccessTokens.Execute(w, r, data)
+}
+
+func (u User) ProcessCreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	var data accessTokensData
+	data.Name = r.FormValue("name")
+	user := context.User(r.Context())
+
+	token, err := u.AccessTokenService.Create(user.ID, data.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNameRequired):
+			err = errors.Public(err, "Please give your token a name.")
+		case errors.Is(err, models.ErrNameTooLong):
+			err = errors.Public(err, fmt.Sprintf(
+				"Token names can be at most %d characters long.", models.MaxAccessTokenNameLength))
+		default:
+			log.Println(err)
+			err = errors.Public(err, "Unable to create the token. Please try again later.")
+		}
+		listErr := u.addAccessTokens(&data, user.ID)
+		if listErr != nil {
+			log.Println(listErr)
+		}
+		u.Templates.AccessTokens.Execute(w, r, data, err)
+		return
+	}
+
+	data.Name = ""
+	data.NewToken = token.Token
+	err = u.addAccessTokens(&data, user.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	u.Templates.AccessTokens.Execute(w, r, data)
+}
+
+func (u User) ProcessRevokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+		return
+	}
+
+	user := context.User(r.Context())
+	err = u.AccessTokenService.Delete(user.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Token not found.", http.StatusNotFound)
+			return
+		}
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/users/tokens", http.StatusFound)
+}
+
+func (u User) addAccessTokens(data *accessTokensData, userID int) error {
+	tokens, err := u.AccessTokenService.ByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		data.Tokens = append(data.Tokens, accessTokenData{
+			ID:         token.ID,
+			Name:       token.Name,
+			CreatedAt:  token.CreatedAt,
+			LastUsedAt: token.LastUsedAt,
+		})
+	}
+
+	return nil
+}
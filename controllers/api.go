@@ -0,0 +1,701 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexproskurov/snapfolio/context"
+	"github.com/alexproskurov/snapfolio/errors"
+	"github.com/alexproskurov/snapfolio/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// MaxAPIGalleriesPerPage limits the per_page parameter of API list
+	// requests.
+	MaxAPIGalleriesPerPage = 100
+	// MaxAPISearchResultsPerPage limits the per_page parameter of API search
+	// requests, which applies to galleries and images each.
+	MaxAPISearchResultsPerPage = 50
+)
+
+// API serves the JSON API under /api/v1. Requests are authenticated with
+// personal access tokens instead of session cookies, so the API is not
+// protected by CSRF tokens.
+type API struct {
+	AccessTokenService *models.AccessTokenService
+	GalleryService     *models.GalleryService
+	ImageService       *models.ImageService
+	SearchService      *models.SearchService
+}
+
+type apiUser struct {
+	ID               int    `json:"id"`
+	Email            string `json:"email"`
+	EmailVerified    bool   `json:"email_verified"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+}
+
+type apiGallery struct {
+	ID             int      `json:"id"`
+	UserID         int      `json:"user_id"`
+	Title          string   `json:"title"`
+	Description    string   `json:"description"`
+	Tags           []string `json:"tags"`
+	Visibility     string   `json:"visibility"`
+	MetadataPolicy string   `json:"metadata_policy"`
+	CoverImageID   int      `json:"cover_image_id,omitempty"`
+	URL            string   `json:"url"`
+}
+
+// apiPublicGallery is a gallery of another user. It leaves out who owns the
+// gallery and its settings.
+type apiPublicGallery struct {
+	ID           int      `json:"id"`
+	Title        string   `json:"title"`
+	Description  string   `json:"description"`
+	Tags         []string `json:"tags"`
+	CoverImageID int      `json:"cover_image_id,omitempty"`
+	URL          string   `json:"url"`
+}
+
+type apiImage struct {
+	ID               int               `json:"id"`
+	GalleryID        int               `json:"gallery_id"`
+	Filename         string            `json:"filename"`
+	OriginalFilename string            `json:"original_filename"`
+	ContentType      string            `json:"content_type"`
+	Size             int64             `json:"size"`
+	Position         int               `json:"position"`
+	Title            string            `json:"title"`
+	Caption          string            `json:"caption"`
+	AltText          string            `json:"alt_text"`
+	Tags             []string          `json:"tags"`
+	CreatedAt        time.Time         `json:"created_at"`
+	URL              string            `json:"url"`
+	Variants         map[string]string `json:"variants"`
+	Metadata         apiImageMetadata  `json:"metadata"`
+}
+
+type apiImageMetadata struct {
+	CapturedAt   *time.Time `json:"captured_at"`
+	Camera       string     `json:"camera,omitempty"`
+	Lens         string     `json:"lens,omitempty"`
+	ExposureTime string     `json:"exposure_time,omitempty"`
+	FNumber      float64    `json:"f_number,omitempty"`
+	ISO          int        `json:"iso,omitempty"`
+	FocalLength  float64    `json:"focal_length,omitempty"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func newAPIGallery(gallery *models.Gallery) apiGallery {
+	url := fmt.Sprintf("/galleries/%d", gallery.ID)
+	if gallery.Visibility == models.VisibilityUnlisted {
+		url += "?key=" + gallery.AccessKey
+	}
+
+	return apiGallery{
+		ID:             gallery.ID,
+		UserID:         gallery.UserID,
+		Title:          gallery.Title,
+		Description:    gallery.Description,
+		Tags:           gallery.Tags,
+		Visibility:     gallery.Visibility,
+		MetadataPolicy: gallery.MetadataPolicy,
+		CoverImageID:   gallery.CoverImageID,
+		URL:            url,
+	}
+}
+
+func newAPIPublicGallery(gallery *models.Gallery) apiPublicGallery {
+	return apiPublicGallery{
+		ID:           gallery.ID,
+		Title:        gallery.Title,
+		Description:  gallery.Description,
+		Tags:         gallery.Tags,
+		CoverImageID: gallery.CoverImageID,
+		URL:          fmt.Sprintf("/galleries/%d", gallery.ID),
+	}
+}
+
+func newAPIImage(image *models.Image) apiImage {
+	url := fmt.Sprintf("/galleries/%d/images/%d", image.GalleryID, image.ID)
+	variants := make(map[string]string, len(models.VariantWidths))
+	for size := range models.VariantWidths {
+		variants[size] = url + "?size=" + size
+	}
+
+	return apiImage{
+		ID:               image.ID,
+		GalleryID:        image.GalleryID,
+		Filename:         image.Filename,
+		OriginalFilename: image.OriginalFilename,
+		ContentType:      image.ContentType,
+		Size:             image.Size,
+		Position:         image.Position,
+		Title:            image.Title,
+		Caption:          image.Caption,
+		AltText:          image.AltText,
+		Tags:             image.Tags,
+		CreatedAt:        image.CreatedAt,
+		URL:              url,
+		Variants:         variants,
+		Metadata: apiImageMetadata{
+			CapturedAt:   image.Metadata.CapturedAt,
+			Camera:       image.Metadata.Camera,
+			Lens:         image.Metadata.Lens,
+			ExposureTime: image.Metadata.ExposureTime,
+			FNumber:      image.Metadata.FNumber,
+			ISO:          image.Metadata.ISO,
+			FocalLength:  image.Metadata.FocalLength,
+		},
+	}
+}
+
+func (a API) CurrentUser(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	writeJSON(w, http.StatusOK, apiUser{
+		ID:               user.ID,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		TwoFactorEnabled: user.TwoFactorEnabled,
+	})
+}
+
+func (a API) Galleries(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Galleries  []apiGallery `json:"galleries"`
+		Page       int          `json:"page"`
+		PerPage    int          `json:"per_page"`
+		TotalCount int          `json:"total_count"`
+	}
+	data.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if data.Page < 1 {
+		data.Page = 1
+	}
+	data.PerPage, _ = strconv.Atoi(r.URL.Query().Get("per_page"))
+	if data.PerPage < 1 {
+		data.PerPage = models.DefaultGalleriesPerPage
+	}
+	if data.PerPage > MaxAPIGalleriesPerPage {
+		data.PerPage = MaxAPIGalleriesPerPage
+	}
+
+	userID := context.User(r.Context()).ID
+	var err error
+	tag := r.URL.Query().Get("tag")
+	data.TotalCount, err = a.GalleryService.CountByUserID(userID, tag)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	galleries, err := a.GalleryService.GetByUserID(userID, models.ListOptions{
+		Page:    data.Page,
+		PerPage: data.PerPage,
+		Tag:     tag,
+	})
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+
+	data.Galleries = make([]apiGallery, len(galleries))
+	for i := range galleries {
+		data.Galleries[i] = newAPIGallery(&galleries[i])
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+func (a API) CreateGallery(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title string `json:"title"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Request body must be a JSON object.")
+		return
+	}
+
+	userID := context.User(r.Context()).ID
+	gallery, err := a.GalleryService.Create(userID, req.Title)
+	if err != nil {
+		a.galleryError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/galleries/%d", gallery.ID))
+	writeJSON(w, http.StatusCreated, newAPIGallery(gallery))
+}
+
+func (a API) Gallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := a.getGalleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIGallery(gallery))
+}
+
+func (a API) UpdateGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := a.getGalleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	var req struct {
+		Title       *string   `json:"title"`
+		Description *string   `json:"description"`
+		Tags        *[]string `json:"tags"`
+		Visibility  *string   `json:"visibility"`
+		// Password is required when changing the visibility to password.
+		Password       string  `json:"password"`
+		MetadataPolicy *string `json:"metadata_policy"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Request body must be a JSON object.")
+		return
+	}
+	if req.Title != nil || req.Description != nil || req.Tags != nil {
+		if req.Title != nil {
+			gallery.Title = *req.Title
+		}
+		if req.Description != nil {
+			gallery.Description = *req.Description
+		}
+		if req.Tags != nil {
+			gallery.Tags = *req.Tags
+		}
+		err = a.GalleryService.Update(gallery)
+		if err != nil {
+			a.galleryError(w, err)
+			return
+		}
+	}
+	if req.Visibility != nil {
+		err = a.GalleryService.SetVisibility(gallery, *req.Visibility, req.Password)
+		if err != nil {
+			a.galleryError(w, err)
+			return
+		}
+	}
+	if req.MetadataPolicy != nil {
+		err = a.GalleryService.SetMetadataPolicy(gallery, *req.MetadataPolicy)
+		if err != nil {
+			a.galleryError(w, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, newAPIGallery(gallery))
+}
+
+func (a API) DeleteGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := a.getGalleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	err = a.GalleryService.Delete(gallery.ID)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a API) Images(w http.ResponseWriter, r *http.Request) {
+	gallery, err := a.getGalleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	images, err := a.ImageService.ByGalleryID(gallery.ID, imageOrder(r))
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+
+	var data struct {
+		Images []apiImage `json:"images"`
+	}
+	data.Images = make([]apiImage, len(images))
+	for i := range images {
+		data.Images[i] = newAPIImage(&images[i])
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+// UploadImages accepts a multipart form with one or more files in the images
+// field, just like the upload form on the edit gallery page.
+func (a API) UploadImages(w http.ResponseWriter, r *http.Request) {
+	gallery, err := a.getGalleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	err = r.ParseMultipartForm(5 << 20) //5MB
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Request body must be a multipart form.")
+		return
+	}
+	fileHeaders := r.MultipartForm.File["images"]
+	if len(fileHeaders) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "No files were uploaded in the images field.")
+		return
+	}
+
+	userID := context.User(r.Context()).ID
+	var data struct {
+		Images []apiImage `json:"images"`
+	}
+	for _, fh := range fileHeaders {
+		file, err := fh.Open()
+		if err != nil {
+			a.internalError(w, err)
+			return
+		}
+		defer file.Close()
+
+		image, err := a.ImageService.Create(gallery.ID, userID, fh.Filename, file)
+		if err != nil {
+			if errors.Is(err, models.ErrTooManyPixels) {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf(
+					"%v is too large. Images can have at most %d megapixels.",
+					fh.Filename, models.MaxImagePixels/1000/1000))
+				return
+			}
+			var fileErr models.FileError
+			if errors.As(err, &fileErr) {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf(
+					"%v has an invalid content type or extension. "+
+						"Only png, gif, jpg files can be uploaded.", fh.Filename))
+				return
+			}
+			a.internalError(w, err)
+			return
+		}
+		data.Images = append(data.Images, newAPIImage(image))
+	}
+
+	writeJSON(w, http.StatusCreated, data)
+}
+
+// ImportImages accepts a multipart form with a ZIP archive in the archive
+// field and adds every image in it to the gallery.
+func (a API) ImportImages(w http.ResponseWriter, r *http.Request) {
+	gallery, err := a.getGalleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxArchiveUploadSize)
+	err = r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Request body must be a multipart form.")
+		return
+	}
+	file, fh, err := r.FormFile("archive")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "No ZIP archive was uploaded in the archive field.")
+		return
+	}
+	defer file.Close()
+
+	userID := context.User(r.Context()).ID
+	result, err := a.ImageService.ImportZip(gallery.ID, userID, file, fh.Size)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidArchive):
+			writeAPIError(w, http.StatusBadRequest, "The archive is not a valid ZIP archive.")
+		case errors.Is(err, models.ErrArchiveTooLarge):
+			writeAPIError(w, http.StatusBadRequest, "The archive contains too many or too large files.")
+		default:
+			a.internalError(w, err)
+		}
+		return
+	}
+
+	type skipped struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	}
+	var data struct {
+		Images  []apiImage `json:"images"`
+		Skipped []skipped  `json:"skipped"`
+	}
+	data.Images = make([]apiImage, len(result.Imported))
+	for i := range result.Imported {
+		data.Images[i] = newAPIImage(&result.Imported[i])
+	}
+	data.Skipped = make([]skipped, len(result.Skipped))
+	for i, file := range result.Skipped {
+		data.Skipped[i] = skipped{
+			Name:   file.Name,
+			Reason: file.Reason,
+		}
+	}
+	writeJSON(w, http.StatusCreated, data)
+}
+
+// UpdateImage changes the title, caption or alt text of an image. Fields
+// missing from the request body are left unchanged.
+func (a API) UpdateImage(w http.ResponseWriter, r *http.Request) {
+	gallery, err := a.getGalleryByID(w, r)
+	if err != nil {
+		return
+	}
+	image, err := a.getImageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	var req struct {
+		Title   *string   `json:"title"`
+		Caption *string   `json:"caption"`
+		AltText *string   `json:"alt_text"`
+		Tags    *[]string `json:"tags"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Request body must be a JSON object.")
+		return
+	}
+	if req.Title != nil {
+		image.Title = *req.Title
+	}
+	if req.Caption != nil {
+		image.Caption = *req.Caption
+	}
+	if req.AltText != nil {
+		image.AltText = *req.AltText
+	}
+	if req.Tags != nil {
+		image.Tags = *req.Tags
+	}
+	err = a.ImageService.Update(image)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrImageTitleTooLong):
+			writeAPIError(w, http.StatusUnprocessableEntity, fmt.Sprintf(
+				"Title can be at most %d characters long.", models.MaxTitleLength))
+		case errors.Is(err, models.ErrCaptionTooLong):
+			writeAPIError(w, http.StatusUnprocessableEntity, fmt.Sprintf(
+				"Caption can be at most %d characters long.", models.MaxCaptionLength))
+		case errors.Is(err, models.ErrAltTextTooLong):
+			writeAPIError(w, http.StatusUnprocessableEntity, fmt.Sprintf(
+				"Alt text can be at most %d characters long.", models.MaxAltTextLength))
+		case errors.Is(err, models.ErrInvalidTag), errors.Is(err, models.ErrTooManyTags):
+			writeAPIError(w, http.StatusUnprocessableEntity, tagErrorMessage(err))
+		default:
+			a.internalError(w, err)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIImage(image))
+}
+
+func (a API) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	gallery, err := a.getGalleryByID(w, r)
+	if err != nil {
+		return
+	}
+	image, err := a.getImageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	err = a.ImageService.Delete(image.ID)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getImageByID looks up the image in the imageID URL parameter. Images of
+// other galleries are reported as not found.
+func (a API) getImageByID(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) (*models.Image, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "imageID"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "Image not found.")
+		return nil, err
+	}
+	image, err := a.ImageService.ByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			writeAPIError(w, http.StatusNotFound, "Image not found.")
+			return nil, err
+		}
+		a.internalError(w, err)
+		return nil, err
+	}
+	if image.GalleryID != gallery.ID {
+		writeAPIError(w, http.StatusNotFound, "Image not found.")
+		return nil, models.ErrNotFound
+	}
+
+	return image, nil
+}
+
+// RequireToken authenticates the request with the bearer token in the
+// Authorization header and adds the token's user to the request context.
+func (a API) RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="snapfolio"`)
+			writeAPIError(w, http.StatusUnauthorized, "A personal access token is required.")
+			return
+		}
+
+		user, err := a.AccessTokenService.User(strings.TrimSpace(token))
+		if err != nil {
+			if !errors.Is(err, models.ErrNotFound) {
+				a.internalError(w, err)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="snapfolio", error="invalid_token"`)
+			writeAPIError(w, http.StatusUnauthorized, "The access token is invalid or has been revoked.")
+			return
+		}
+
+		ctx := context.WithUser(r.Context(), user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// getGalleryByID looks up the gallery identified by the id URL parameter.
+// Tokens only give access to their user's own galleries. If the gallery
+// can't be accessed, an error response is written and an error is returned.
+func (a API) getGalleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "Gallery not found.")
+		return nil, err
+	}
+	gallery, err := a.GalleryService.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			writeAPIError(w, http.StatusNotFound, "Gallery not found.")
+			return nil, err
+		}
+		a.internalError(w, err)
+		return nil, err
+	}
+	if gallery.UserID != context.User(r.Context()).ID {
+		writeAPIError(w, http.StatusNotFound, "Gallery not found.")
+		return nil, fmt.Errorf("user does not have access to this gallery")
+	}
+
+	return gallery, nil
+}
+
+func (a API) galleryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrTitleRequired):
+		writeAPIError(w, http.StatusUnprocessableEntity, "Title is required.")
+	case errors.Is(err, models.ErrTitleTooLong):
+		writeAPIError(w, http.StatusUnprocessableEntity, fmt.Sprintf(
+			"Title can be at most %d characters long.", models.MaxTitleLength))
+	case errors.Is(err, models.ErrDescriptionTooLong):
+		writeAPIError(w, http.StatusUnprocessableEntity, fmt.Sprintf(
+			"Description can be at most %d characters long.", models.MaxDescriptionLength))
+	case errors.Is(err, models.ErrInvalidTag), errors.Is(err, models.ErrTooManyTags):
+		writeAPIError(w, http.StatusUnprocessableEntity, tagErrorMessage(err))
+	case errors.Is(err, models.ErrInvalidVisibility):
+		writeAPIError(w, http.StatusUnprocessableEntity, fmt.Sprintf(
+			"Visibility must be one of %v.", strings.Join(models.Visibilities, ", ")))
+	case errors.Is(err, models.ErrPasswordRequired):
+		writeAPIError(w, http.StatusUnprocessableEntity, "Password is required for password-protected galleries.")
+	case errors.Is(err, models.ErrInvalidMetadataPolicy):
+		writeAPIError(w, http.StatusUnprocessableEntity, fmt.Sprintf(
+			"Metadata policy must be one of %v.", strings.Join(models.MetadataPolicies, ", ")))
+	default:
+		a.internalError(w, err)
+	}
+}
+
+// Search returns the galleries and images matching the q query parameter
+// that the user may see, best match first. Both lists are paginated with the
+// same page and per_page parameters. Galleries of other users are returned
+// as an apiPublicGallery.
+func (a API) Search(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Galleries []interface{} `json:"galleries"`
+		Images    []apiImage    `json:"images"`
+		Page      int           `json:"page"`
+		PerPage   int           `json:"per_page"`
+	}
+	data.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if data.Page < 1 {
+		data.Page = 1
+	}
+	data.PerPage, _ = strconv.Atoi(r.URL.Query().Get("per_page"))
+	if data.PerPage < 1 {
+		data.PerPage = models.DefaultSearchResultsPerPage
+	}
+	if data.PerPage > MaxAPISearchResultsPerPage {
+		data.PerPage = MaxAPISearchResultsPerPage
+	}
+
+	userID := context.User(r.Context()).ID
+	query := r.URL.Query().Get("q")
+	opts := models.ListOptions{
+		Page:    data.Page,
+		PerPage: data.PerPage,
+	}
+	galleries, err := a.SearchService.Galleries(userID, query, opts)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	images, err := a.SearchService.Images(userID, query, opts)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+
+	data.Galleries = make([]interface{}, len(galleries))
+	for i := range galleries {
+		if galleries[i].UserID == userID {
+			data.Galleries[i] = newAPIGallery(&galleries[i])
+		} else {
+			data.Galleries[i] = newAPIPublicGallery(&galleries[i])
+		}
+	}
+	data.Images = make([]apiImage, len(images))
+	for i := range images {
+		data.Images[i] = newAPIImage(&images[i])
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+func (a API) internalError(w http.ResponseWriter, err error) {
+	log.Println(err)
+	writeAPIError(w, http.StatusInternalServerError, "Something went wrong.")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("encoding json response: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg})
+}
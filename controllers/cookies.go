@@ -3,10 +3,15 @@ package controllers
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/alexproskurov/snapfolio/models"
 )
 
 const (
 	CookieSession = "session"
+	// CookiePendingSignIn identifies a sign in that is waiting for the
+	// user's second factor.
+	CookiePendingSignIn = "pending_signin"
 )
 
 func newCookie(name, value string) *http.Cookie {
@@ -23,6 +28,14 @@ func setCookie(w http.ResponseWriter, name, value string) {
 	http.SetCookie(w, cookie)
 }
 
+// setSessionCookie sets the session cookie so that it expires together with
+// the session.
+func setSessionCookie(w http.ResponseWriter, session *models.Session) {
+	cookie := newCookie(CookieSession, session.Token)
+	cookie.Expires = session.ExpiresAt
+	http.SetCookie(w, cookie)
+}
+
 func readCookie(r *http.Request, name string) (string, error) {
 	c, err := r.Cookie(name)
 	if err != nil {
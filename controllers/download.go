@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"archive/zip"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/alexproskurov/snapfolio/errors"
+	"github.com/alexproskurov/snapfolio/models"
+)
+
+// Download streams a ZIP archive of every image in the gallery.
+func (g Gallery) Download(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, g.userCanViewGallery)
+	if err != nil {
+		return
+	}
+
+	g.streamZip(w, gallery)
+}
+
+// SharedDownload streams a ZIP archive of the share link's gallery, if the
+// link allows downloads.
+func (g Gallery) SharedDownload(w http.ResponseWriter, r *http.Request) {
+	link, gallery, err := g.getSharedGallery(w, r)
+	if err != nil {
+		return
+	}
+	if !link.AllowDownload {
+		http.Error(w, "Downloads are not allowed for this link.", http.StatusForbidden)
+		return
+	}
+
+	g.streamZip(w, gallery)
+}
+
+// streamZip writes the gallery's original image files to w as a ZIP archive
+// while they are read from the storage, so that large galleries don't have to
+// fit in memory or on disk.
+func (g Gallery) streamZip(w http.ResponseWriter, gallery *models.Gallery) {
+	images, err := g.ImageService.ByGalleryID(gallery.ID, models.OrderPosition)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": zipFilename(gallery.Title),
+	}))
+
+	// Once the first byte has been written the status can't be changed
+	// anymore, so errors from here on can only be logged. The client will
+	// notice the truncated archive.
+	zw := zip.NewWriter(w)
+	for i := range images {
+		err = g.addToZip(zw, &images[i])
+		if err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				log.Printf("gallery %d zip: skipping %v: %v", gallery.ID, images[i].Filename, err)
+				continue
+			}
+			log.Printf("gallery %d zip: %v", gallery.ID, err)
+			return
+		}
+	}
+	err = zw.Close()
+	if err != nil {
+		log.Printf("gallery %d zip: %v", gallery.ID, err)
+	}
+}
+
+func (g Gallery) addToZip(zw *zip.Writer, image *models.Image) error {
+	f, err := g.ImageService.Open(image)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Images are compressed already, so storing them is much cheaper than
+	// deflating them and makes the archive barely larger.
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     image.Filename,
+		Method:   zip.Store,
+		Modified: image.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+// zipFilename returns the name of the ZIP archive for a gallery with the
+// given title, keeping only characters that are safe in filenames.
+func zipFilename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_':
+			return r
+		case unicode.IsSpace(r), r == '.':
+			return '-'
+		default:
+			return -1
+		}
+	}, title)
+	name = strings.Trim(name, "-")
+	if name == "" {
+		name = "gallery"
+	}
+
+	return name + ".zip"
+}
@@ -3,10 +3,12 @@ package controllers
 import (
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
-	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alexproskurov/snapfolio/context"
 	"github.com/alexproskurov/snapfolio/errors"
@@ -20,8 +22,24 @@ type Gallery struct {
 		Show  Template
 		Edit  Template
 		Index Template
-	}
-	GalleryService *models.GalleryService
+		// Unlock asks for the password of a password-protected gallery.
+		Unlock Template
+		// Import reports the result of importing a ZIP archive.
+		Import Template
+		// ImageInfo shows an image with its camera settings.
+		ImageInfo Template
+		// Tag lists the galleries and images with a tag.
+		Tag Template
+		// Trash lists the deleted galleries and images.
+		Trash Template
+	}
+	GalleryService   *models.GalleryService
+	ImageService     *models.ImageService
+	ShareLinkService *models.ShareLinkService
+	TrashService     *models.TrashService
+	// UnlockKey signs the cookies that give visitors access to
+	// password-protected and unlisted galleries.
+	UnlockKey []byte
 }
 
 func (g Gallery) New(w http.ResponseWriter, r *http.Request) {
@@ -42,6 +60,16 @@ func (g Gallery) Create(w http.ResponseWriter, r *http.Request) {
 
 	gallery, err := g.GalleryService.Create(data.UserID, data.Title)
 	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTitleRequired):
+			err = errors.Public(err, "Please give your gallery a title.")
+		case errors.Is(err, models.ErrTitleTooLong):
+			err = errors.Public(err, fmt.Sprintf(
+				"Gallery titles can be at most %d characters long.", models.MaxTitleLength))
+		default:
+			log.Println(err)
+			err = errors.Public(err, "Unable to create the gallery. Please try again later.")
+		}
 		g.Templates.New.Execute(w, r, data, err)
 		return
 	}
@@ -51,24 +79,42 @@ func (g Gallery) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 func (g Gallery) Show(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.getGalleryByID(w, r)
+	gallery, err := g.getGalleryByID(w, r, g.userCanViewGallery)
 	if err != nil {
 		return
 	}
 
 	type Image struct {
-		GalleryID       int
-		Filename        string
-		FilenameEscaped string
+		ID        int
+		GalleryID int
+		Filename  string
+		Title     string
+		Caption   string
+		AltText   string
+		// URL is where the image and its variants are served.
+		URL string
+		// DownloadURL is only set if the visitor may download the original.
+		DownloadURL string
 	}
 	var data struct {
-		ID     int
-		Title  string
-		Images []Image
+		ID    int
+		Title string
+		// Description is Markdown, which the template renders.
+		Description string
+		Images      []Image
+		DownloadURL string
+		// Sort is the order of the images, see models.ImageOrder.
+		Sort    string
+		Preview *preview
 	}
 	data.ID = gallery.ID
 	data.Title = gallery.Title
-	images, err := g.GalleryService.Images(gallery.ID)
+	data.Description = gallery.Description
+	data.Preview = galleryPreview(gallery)
+	data.DownloadURL = fmt.Sprintf("/galleries/%d/download", gallery.ID)
+	order := imageOrder(r)
+	data.Sort = string(order)
+	images, err := g.ImageService.ByGalleryID(gallery.ID, order)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
@@ -76,9 +122,13 @@ func (g Gallery) Show(w http.ResponseWriter, r *http.Request) {
 	}
 	for _, image := range images {
 		data.Images = append(data.Images, Image{
-			GalleryID:       image.GalleryID,
-			Filename:        image.Filename,
-			FilenameEscaped: url.PathEscape(image.Filename),
+			ID:        image.ID,
+			GalleryID: image.GalleryID,
+			Filename:  image.OriginalFilename,
+			Title:     image.Title,
+			Caption:   image.Caption,
+			AltText:   image.AltText,
+			URL:       fmt.Sprintf("/galleries/%d/images/%d", image.GalleryID, image.ID),
 		})
 	}
 
@@ -91,19 +141,66 @@ func (g Gallery) Edit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	g.renderEdit(w, r, gallery, "")
+}
+
+// renderEdit renders the edit page of the gallery. newShareURL is the URL of
+// a share link that was just created, as it can't be shown again later.
+func (g Gallery) renderEdit(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, newShareURL string, errs ...error) {
 	type Image struct {
-		GalleryID       int
-		Filename        string
-		FilenameEscaped string
+		ID        int
+		GalleryID int
+		Filename  string
+		Title     string
+		Caption   string
+		AltText   string
+		// Tags is a comma separated list, as it is entered in the form.
+		Tags string
+	}
+	type ShareLink struct {
+		ID            int
+		AllowDownload bool
+		ViewCount     int
+		CreatedAt     time.Time
+		ExpiresAt     time.Time
+		Revoked       bool
+		Active        bool
 	}
 	var data struct {
-		ID     int
-		Title  string
-		Images []Image
+		ID           int
+		Title        string
+		Description  string
+		Tags         string
+		Images       []Image
+		Visibility   string
+		Visibilities []string
+		HasPassword  bool
+		UnlistedURL  string
+		ShareLinks   []ShareLink
+		NewShareURL  string
+		// MetadataPolicy is one of MetadataPolicies.
+		MetadataPolicy   string
+		MetadataPolicies []string
+		CoverImageID     int
 	}
 	data.ID = gallery.ID
 	data.Title = gallery.Title
-	images, err := g.GalleryService.Images(gallery.ID)
+	data.Description = gallery.Description
+	data.Tags = strings.Join(gallery.Tags, ", ")
+	data.Visibility = gallery.Visibility
+	data.Visibilities = models.Visibilities
+	data.MetadataPolicy = gallery.MetadataPolicy
+	data.MetadataPolicies = models.MetadataPolicies
+	data.CoverImageID = gallery.CoverImageID
+	data.HasPassword = gallery.PasswordHash != ""
+	if gallery.Visibility == models.VisibilityUnlisted {
+		vals := url.Values{
+			"key": {gallery.AccessKey},
+		}
+		data.UnlistedURL = fmt.Sprintf("/galleries/%d?", gallery.ID) + vals.Encode()
+	}
+	data.NewShareURL = newShareURL
+	images, err := g.ImageService.ByGalleryID(gallery.ID, models.OrderPosition)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
@@ -111,13 +208,34 @@ func (g Gallery) Edit(w http.ResponseWriter, r *http.Request) {
 	}
 	for _, image := range images {
 		data.Images = append(data.Images, Image{
-			GalleryID:       image.GalleryID,
-			Filename:        image.Filename,
-			FilenameEscaped: url.PathEscape(image.Filename),
+			ID:        image.ID,
+			GalleryID: image.GalleryID,
+			Filename:  image.OriginalFilename,
+			Title:     image.Title,
+			Caption:   image.Caption,
+			AltText:   image.AltText,
+			Tags:      strings.Join(image.Tags, ", "),
+		})
+	}
+	links, err := g.ShareLinkService.ByGalleryID(gallery.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	for _, link := range links {
+		data.ShareLinks = append(data.ShareLinks, ShareLink{
+			ID:            link.ID,
+			AllowDownload: link.AllowDownload,
+			ViewCount:     link.ViewCount,
+			CreatedAt:     link.CreatedAt,
+			ExpiresAt:     link.ExpiresAt,
+			Revoked:       link.RevokedAt != nil,
+			Active:        link.Active(),
 		})
 	}
 
-	g.Templates.Edit.Execute(w, r, data)
+	g.Templates.Edit.Execute(w, r, data, errs...)
 }
 
 func (g Gallery) Update(w http.ResponseWriter, r *http.Request) {
@@ -127,32 +245,156 @@ func (g Gallery) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	gallery.Title = r.FormValue("title")
+	gallery.Description = r.FormValue("description")
+	gallery.Tags = models.ParseTags(r.FormValue("tags"))
 	err = g.GalleryService.Update(gallery)
 	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTitleRequired):
+			err = errors.Public(err, "Please give your gallery a title.")
+		case errors.Is(err, models.ErrTitleTooLong):
+			err = errors.Public(err, fmt.Sprintf(
+				"Gallery titles can be at most %d characters long.", models.MaxTitleLength))
+		case errors.Is(err, models.ErrDescriptionTooLong):
+			err = errors.Public(err, fmt.Sprintf(
+				"Gallery descriptions can be at most %d characters long.", models.MaxDescriptionLength))
+		case errors.Is(err, models.ErrInvalidTag), errors.Is(err, models.ErrTooManyTags):
+			err = errors.Public(err, tagErrorMessage(err))
+		default:
+			log.Println(err)
+			err = errors.Public(err, "Unable to update the gallery. Please try again later.")
+		}
+		g.renderEdit(w, r, gallery, "", err)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Gallery) UpdateVisibility(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, userMustOwnGallery)
+	if err != nil {
+		return
+	}
+
+	err = g.GalleryService.SetVisibility(gallery, r.FormValue("visibility"), r.FormValue("password"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidVisibility):
+			http.Error(w, "Invalid visibility.", http.StatusBadRequest)
+		case errors.Is(err, models.ErrPasswordRequired):
+			http.Error(w, "Please choose a password for the gallery.", http.StatusBadRequest)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Gallery) UpdateMetadataPolicy(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, userMustOwnGallery)
+	if err != nil {
+		return
+	}
+
+	err = g.GalleryService.SetMetadataPolicy(gallery, r.FormValue("metadata_policy"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidMetadataPolicy) {
+			http.Error(w, "Invalid metadata policy.", http.StatusBadRequest)
+			return
+		}
+		log.Println(err)
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
 		return
 	}
+
 	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
 	http.Redirect(w, r, editPath, http.StatusFound)
 }
 
+func (g Gallery) Unlock(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, galleryMustHavePassword)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		ID int
+	}
+	data.ID = gallery.ID
+	g.Templates.Unlock.Execute(w, r, data)
+}
+
+func (g Gallery) ProcessUnlock(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, galleryMustHavePassword)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		ID int
+	}
+	data.ID = gallery.ID
+	err = g.GalleryService.CheckPassword(gallery, r.FormValue("password"))
+	if err != nil {
+		err = errors.Public(err, "That password is incorrect.")
+		g.Templates.Unlock.Execute(w, r, data, err)
+		return
+	}
+
+	g.setUnlockCookie(w, gallery)
+	http.Redirect(w, r, fmt.Sprintf("/galleries/%d", gallery.ID), http.StatusFound)
+}
+
 func (g Gallery) Index(w http.ResponseWriter, r *http.Request) {
 	type Gallery struct {
-		ID    int
-		Title string
+		ID           int
+		Title        string
+		Visibility   string
+		CoverImageID int
+		Tags         []string
 	}
 	var data struct {
 		Galleries []Gallery
+		// Tag, if set, limits the list to galleries with the tag.
+		Tag        string
+		Page       int
+		TotalPages int
+		PrevPage   int
+		NextPage   int
+	}
+
+	data.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if data.Page < 1 {
+		data.Page = 1
 	}
+	data.Tag = r.URL.Query().Get("tag")
 
 	userID := context.User(r.Context()).ID
-	galleries, err := g.GalleryService.GetByUserID(userID)
+	total, err := g.GalleryService.CountByUserID(userID, data.Tag)
 	if err != nil {
-		if errors.Is(err, models.ErrNotFound) {
-			err = errors.Public(err, "No galleries available.")
-			g.Templates.Index.Execute(w, r, data, err)
-			return
-		}
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	data.TotalPages = (total + models.DefaultGalleriesPerPage - 1) / models.DefaultGalleriesPerPage
+	if data.Page > 1 {
+		data.PrevPage = data.Page - 1
+	}
+	if data.Page < data.TotalPages {
+		data.NextPage = data.Page + 1
+	}
+	galleries, err := g.GalleryService.GetByUserID(userID, models.ListOptions{
+		Page:    data.Page,
+		PerPage: models.DefaultGalleriesPerPage,
+		Tag:     data.Tag,
+	})
+	if err != nil {
+		log.Println(err)
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
 		return
 	}
@@ -160,8 +402,11 @@ func (g Gallery) Index(w http.ResponseWriter, r *http.Request) {
 	data.Galleries = make([]Gallery, len(galleries))
 	for i, gallery := range galleries {
 		data.Galleries[i] = Gallery{
-			ID:    gallery.ID,
-			Title: gallery.Title,
+			ID:           gallery.ID,
+			Title:        gallery.Title,
+			Visibility:   gallery.Visibility,
+			CoverImageID: gallery.CoverImageID,
+			Tags:         gallery.Tags,
 		}
 	}
 	g.Templates.Index.Execute(w, r, data)
@@ -186,13 +431,56 @@ func (g Gallery) Delete(w http.ResponseWriter, r *http.Request) {
 }
 
 func (g Gallery) Image(w http.ResponseWriter, r *http.Request) {
-	filename := g.filename(w, r)
-	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	gallery, err := g.getGalleryByID(w, r, g.userCanViewGallery)
 	if err != nil {
-		http.Error(w, "Invalid ID.", http.StatusNotFound)
 		return
 	}
-	image, err := g.GalleryService.Image(galleryID, filename)
+	image, err := g.getImageByID(w, r, gallery.ID)
+	if err != nil {
+		return
+	}
+
+	if r.URL.Query().Get("original") == "true" {
+		g.serveKeptOriginal(w, r, gallery, image)
+		return
+	}
+	size := r.URL.Query().Get("size")
+	if size != "" {
+		if _, ok := models.VariantWidths[size]; !ok {
+			http.Error(w, "Invalid image size.", http.StatusBadRequest)
+			return
+		}
+		err = g.serveVariant(w, r, image, size)
+		if err == nil {
+			return
+		}
+		// Variants of images that haven't been processed yet don't exist, so
+		// fall back to serving the original.
+		if !errors.Is(err, models.ErrNotFound) {
+			log.Println(err)
+		}
+	}
+
+	g.serveOriginal(w, r, image)
+}
+
+// serveVariant serves the variant of the image for the given size. If the
+// variant can't be opened nothing is written and the error is returned.
+func (g Gallery) serveVariant(w http.ResponseWriter, r *http.Request, image *models.Image, size string) error {
+	variant := g.ImageService.Variant(image, size)
+	f, err := g.ImageService.OpenVariant(variant)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", variant.ContentType)
+	http.ServeContent(w, r, image.Filename, image.CreatedAt, f)
+	return nil
+}
+
+func (g Gallery) serveOriginal(w http.ResponseWriter, r *http.Request, image *models.Image) {
+	f, err := g.ImageService.Open(image)
 	if err != nil {
 		if errors.Is(err, models.ErrNotFound) {
 			http.Error(w, "Image not found.", http.StatusNotFound)
@@ -202,8 +490,38 @@ func (g Gallery) Image(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
 		return
 	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", image.ContentType)
+	http.ServeContent(w, r, image.Filename, image.CreatedAt, f)
+}
+
+// serveKeptOriginal lets the owner of the gallery download the image as it
+// was uploaded, before its metadata was stripped.
+func (g Gallery) serveKeptOriginal(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, image *models.Image) {
+	user := context.User(r.Context())
+	if user == nil || user.ID != gallery.UserID {
+		http.Error(w, "Image not found.", http.StatusNotFound)
+		return
+	}
+	f, err := g.ImageService.OpenOriginal(image)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "The original of this image was not kept.", http.StatusNotFound)
+			return
+		}
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
 
-	http.ServeFile(w, r, image.Path)
+	w.Header().Set("Content-Type", image.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": image.OriginalFilename,
+	}))
+	w.Header().Set("Cache-Control", "private")
+	http.ServeContent(w, r, image.Filename, image.CreatedAt, f)
 }
 
 func (g Gallery) UploadImage(w http.ResponseWriter, r *http.Request) {
@@ -217,6 +535,7 @@ func (g Gallery) UploadImage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
 		return
 	}
+	userID := context.User(r.Context()).ID
 	fileHeaders := r.MultipartForm.File["images"]
 	for _, fh := range fileHeaders {
 		file, err := fh.Open()
@@ -226,8 +545,14 @@ func (g Gallery) UploadImage(w http.ResponseWriter, r *http.Request) {
 		}
 		defer file.Close()
 
-		err = g.GalleryService.CreateImage(gallery.ID, fh.Filename, file)
+		_, err = g.ImageService.Create(gallery.ID, userID, fh.Filename, file)
 		if err != nil {
+			if errors.Is(err, models.ErrTooManyPixels) {
+				msg := fmt.Sprintf("%v is too large. Images can have at most %d megapixels.",
+					fh.Filename, models.MaxImagePixels/1000/1000)
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
 			var fileErr models.FileError
 			if errors.As(err, &fileErr) {
 				msg := fmt.Sprintf("%v has an invalid content type or extension. "+
@@ -243,13 +568,152 @@ func (g Gallery) UploadImage(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, editPath, http.StatusFound)
 }
 
-func (g Gallery) DeleteImage(w http.ResponseWriter, r *http.Request) {
-	filename := g.filename(w, r)
+// MaxArchiveUploadSize is the largest ZIP archive that can be uploaded to
+// ImportImages.
+const MaxArchiveUploadSize = models.MaxZipTotalSize
+
+// ImportImages adds every image in an uploaded ZIP archive to the gallery and
+// reports the files that were skipped.
+func (g Gallery) ImportImages(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, userMustOwnGallery)
+	if err != nil {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxArchiveUploadSize)
+	err = r.ParseMultipartForm(32 << 20) // larger archives are buffered on disk
+	if err != nil {
+		http.Error(w, "The archive is too large or could not be read.", http.StatusBadRequest)
+		return
+	}
+	file, fh, err := r.FormFile("archive")
+	if err != nil {
+		http.Error(w, "Please choose a ZIP archive to import.", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	userID := context.User(r.Context()).ID
+	result, err := g.ImageService.ImportZip(gallery.ID, userID, file, fh.Size)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidArchive):
+			http.Error(w, fmt.Sprintf("%v is not a valid ZIP archive.", fh.Filename), http.StatusBadRequest)
+		case errors.Is(err, models.ErrArchiveTooLarge):
+			http.Error(w, fmt.Sprintf("%v contains too many or too large files.", fh.Filename), http.StatusBadRequest)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var data struct {
+		ID       int
+		Title    string
+		Archive  string
+		Imported int
+		Skipped  []models.SkippedFile
+	}
+	data.ID = gallery.ID
+	data.Title = gallery.Title
+	data.Archive = fh.Filename
+	data.Imported = len(result.Imported)
+	data.Skipped = result.Skipped
+	g.Templates.Import.Execute(w, r, data)
+}
+
+// ReorderImages saves the order of the gallery's images chosen on the edit
+// page. The image_ids form values list every image in the new order.
+func (g Gallery) ReorderImages(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, userMustOwnGallery)
+	if err != nil {
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		http.Error(w, "Invalid form.", http.StatusBadRequest)
+		return
+	}
+	var ids []int
+	for _, v := range r.PostForm["image_ids"] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid image ID.", http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	err = g.ImageService.Reorder(gallery.ID, ids)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidOrder) {
+			http.Error(w, "The images of the gallery have changed. Please reload the page.", http.StatusConflict)
+			return
+		}
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	// The order is saved in the background while the page stays open, so
+	// there is nothing to redirect to.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateImage saves the title, caption and alt text of an image.
+func (g Gallery) UpdateImage(w http.ResponseWriter, r *http.Request) {
 	gallery, err := g.getGalleryByID(w, r, userMustOwnGallery)
 	if err != nil {
 		return
 	}
-	err = g.GalleryService.DeleteImage(gallery.ID, filename)
+	image, err := g.getImageByID(w, r, gallery.ID)
+	if err != nil {
+		return
+	}
+
+	image.Title = r.FormValue("title")
+	image.Caption = r.FormValue("caption")
+	image.AltText = r.FormValue("alt_text")
+	image.Tags = models.ParseTags(r.FormValue("tags"))
+	err = g.ImageService.Update(image)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrImageTitleTooLong):
+			err = errors.Public(err, fmt.Sprintf(
+				"Image titles can be at most %d characters long.", models.MaxTitleLength))
+		case errors.Is(err, models.ErrCaptionTooLong):
+			err = errors.Public(err, fmt.Sprintf(
+				"Captions can be at most %d characters long.", models.MaxCaptionLength))
+		case errors.Is(err, models.ErrAltTextTooLong):
+			err = errors.Public(err, fmt.Sprintf(
+				"Alt text can be at most %d characters long.", models.MaxAltTextLength))
+		case errors.Is(err, models.ErrInvalidTag), errors.Is(err, models.ErrTooManyTags):
+			err = errors.Public(err, tagErrorMessage(err))
+		default:
+			log.Println(err)
+			err = errors.Public(err, "Unable to update the image. Please try again later.")
+		}
+		g.renderEdit(w, r, gallery, "", err)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Gallery) SetCover(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, userMustOwnGallery)
+	if err != nil {
+		return
+	}
+	image, err := g.getImageByID(w, r, gallery.ID)
+	if err != nil {
+		return
+	}
+
+	err = g.GalleryService.SetCover(gallery, image.ID)
 	if err != nil {
 		if errors.Is(err, models.ErrNotFound) {
 			http.Error(w, "Image not found.", http.StatusNotFound)
@@ -264,9 +728,51 @@ func (g Gallery) DeleteImage(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, editPath, http.StatusFound)
 }
 
-func (g Gallery) filename(w http.ResponseWriter, r *http.Request) string {
-	filename := chi.URLParam(r, "filename")
-	return filepath.Base(filename)
+func (g Gallery) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, userMustOwnGallery)
+	if err != nil {
+		return
+	}
+	image, err := g.getImageByID(w, r, gallery.ID)
+	if err != nil {
+		return
+	}
+	err = g.ImageService.Delete(image.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// getImageByID looks up the image identified by the imageID URL parameter and
+// makes sure it belongs to the gallery. If it does not, an error response is
+// written and an error is returned.
+func (g Gallery) getImageByID(w http.ResponseWriter, r *http.Request, galleryID int) (*models.Image, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "imageID"))
+	if err != nil {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+		return nil, err
+	}
+	image, err := g.ImageService.ByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Image not found.", http.StatusNotFound)
+			return nil, err
+		}
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return nil, err
+	}
+	if image.GalleryID != galleryID {
+		http.Error(w, "Image not found.", http.StatusNotFound)
+		return nil, fmt.Errorf("image %d does not belong to gallery %d", image.ID, galleryID)
+	}
+
+	return image, nil
 }
 
 type galleryOpt func(http.ResponseWriter, *http.Request, *models.Gallery) error
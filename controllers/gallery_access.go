@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexproskurov/snapfolio/context"
+	"github.com/alexproskurov/snapfolio/models"
+)
+
+// galleryUnlockDuration is how long a visitor can view a password-protected
+// or unlisted gallery after unlocking it.
+const galleryUnlockDuration = 24 * time.Hour
+
+// userCanViewGallery is a galleryOpt that enforces the gallery's visibility.
+// Owners can always view their galleries. Password-protected galleries
+// redirect to the unlock page until the visitor has entered the password.
+func (g Gallery) userCanViewGallery(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+	user := context.User(r.Context())
+	if user != nil && user.ID == gallery.UserID {
+		return nil
+	}
+
+	switch gallery.Visibility {
+	case models.VisibilityPublic:
+		return nil
+	case models.VisibilityUnlisted:
+		if g.unlocked(r, gallery) {
+			return nil
+		}
+		key := r.URL.Query().Get("key")
+		if key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(gallery.AccessKey)) == 1 {
+			// Images on the page are requested without the key, so remember
+			// that the visitor was given access.
+			g.setUnlockCookie(w, gallery)
+			return nil
+		}
+	case models.VisibilityPassword:
+		if g.unlocked(r, gallery) {
+			return nil
+		}
+		http.Redirect(w, r, fmt.Sprintf("/galleries/%d/unlock", gallery.ID), http.StatusFound)
+		return fmt.Errorf("gallery %d is locked", gallery.ID)
+	}
+
+	// Don't reveal that private galleries exist.
+	http.Error(w, "Gallery not found.", http.StatusNotFound)
+	return fmt.Errorf("user does not have access to this gallery")
+}
+
+// galleryMustHavePassword is a galleryOpt that only finds password-protected
+// galleries. The unlock page responds the same way for every other gallery,
+// so it doesn't reveal which private galleries exist.
+func galleryMustHavePassword(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+	if gallery.Visibility != models.VisibilityPassword {
+		http.Error(w, "Gallery not found.", http.StatusNotFound)
+		return fmt.Errorf("gallery %d is not password-protected", gallery.ID)
+	}
+	return nil
+}
+
+// setUnlockCookie gives the visitor access to the gallery for
+// galleryUnlockDuration. The cookie is signed so that it can't be forged,
+// and it stops working when the gallery's password or access key changes.
+func (g Gallery) setUnlockCookie(w http.ResponseWriter, gallery *models.Gallery) {
+	expires := time.Now().Add(galleryUnlockDuration)
+	value := strconv.FormatInt(expires.Unix(), 10) + "." + g.unlockSignature(gallery, expires.Unix())
+
+	cookie := newCookie(unlockCookieName(gallery.ID), value)
+	cookie.Path = fmt.Sprintf("/galleries/%d", gallery.ID)
+	cookie.Expires = expires
+	http.SetCookie(w, cookie)
+}
+
+// unlocked reports whether the request has a valid unlock cookie for the
+// gallery.
+func (g Gallery) unlocked(r *http.Request, gallery *models.Gallery) bool {
+	value, err := readCookie(r, unlockCookieName(gallery.ID))
+	if err != nil {
+		return false
+	}
+	expiresStr, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(g.unlockSignature(gallery, expires)))
+}
+
+func (g Gallery) unlockSignature(gallery *models.Gallery, expires int64) string {
+	// The secret that grants access is part of the signed message, so
+	// changing it invalidates every cookie issued before.
+	secret := gallery.PasswordHash
+	if gallery.Visibility == models.VisibilityUnlisted {
+		secret = gallery.AccessKey
+	}
+
+	mac := hmac.New(sha256.New, g.UnlockKey)
+	fmt.Fprintf(mac, "%d|%s|%d|%s", gallery.ID, gallery.Visibility, expires, secret)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func unlockCookieName(galleryID int) string {
+	return fmt.Sprintf("gallery_%d", galleryID)
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/alexproskurov/snapfolio/context"
+	"github.com/alexproskurov/snapfolio/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// imageInfo is the data of the image detail page.
+type imageInfo struct {
+	// Title is the title of the gallery.
+	Title    string
+	Filename string
+	// ImageTitle, Caption and AltText are written by the owner.
+	ImageTitle string
+	Caption    string
+	AltText    string
+	// GalleryURL links back to the gallery the image was opened from.
+	GalleryURL string
+	URL        string
+	// DownloadURL is only set if the visitor may download the original.
+	DownloadURL string
+	// OriginalURL is only set for the owner, if the file was kept as it was
+	// uploaded.
+	OriginalURL string
+	// Details are the recorded camera settings, in display order. Settings
+	// that weren't recorded are left out.
+	Details []imageDetail
+}
+
+type imageDetail struct {
+	Name  string
+	Value string
+}
+
+// ImageInfo shows an image of the gallery with its camera settings.
+func (g Gallery) ImageInfo(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, g.userCanViewGallery)
+	if err != nil {
+		return
+	}
+	image, err := g.getImageByID(w, r, gallery.ID)
+	if err != nil {
+		return
+	}
+
+	url := fmt.Sprintf("/galleries/%d/images/%d", gallery.ID, image.ID)
+	data := newImageInfo(image, gallery.Title, fmt.Sprintf("/galleries/%d", gallery.ID), url)
+	data.DownloadURL = url
+	user := context.User(r.Context())
+	if user != nil && user.ID == gallery.UserID && image.OriginalKey != "" {
+		data.OriginalURL = url + "?original=true"
+	}
+	g.Templates.ImageInfo.Execute(w, r, data)
+}
+
+// SharedImageInfo shows an image of the share link's gallery with its camera
+// settings.
+func (g Gallery) SharedImageInfo(w http.ResponseWriter, r *http.Request) {
+	link, gallery, err := g.getSharedGallery(w, r)
+	if err != nil {
+		return
+	}
+	image, err := g.getImageByID(w, r, gallery.ID)
+	if err != nil {
+		return
+	}
+
+	token := chi.URLParam(r, "token")
+	url := fmt.Sprintf("/s/%s/images/%d", token, image.ID)
+	data := newImageInfo(image, gallery.Title, "/s/"+token, url)
+	if link.AllowDownload {
+		data.DownloadURL = url + "?download=true"
+	}
+	g.Templates.ImageInfo.Execute(w, r, data)
+}
+
+func newImageInfo(image *models.Image, title, galleryURL, url string) imageInfo {
+	data := imageInfo{
+		Title:      title,
+		Filename:   image.OriginalFilename,
+		ImageTitle: image.Title,
+		Caption:    image.Caption,
+		AltText:    image.AltText,
+		GalleryURL: galleryURL,
+		URL:        url,
+	}
+	add := func(name, value string) {
+		if value != "" {
+			data.Details = append(data.Details, imageDetail{Name: name, Value: value})
+		}
+	}
+
+	md := image.Metadata
+	if md.CapturedAt != nil {
+		add("Taken", md.CapturedAt.UTC().Format("2 January 2006, 15:04"))
+	}
+	add("Camera", md.Camera)
+	add("Lens", md.Lens)
+	if md.ExposureTime != "" {
+		add("Exposure", md.ExposureTime+" s")
+	}
+	if md.FNumber > 0 {
+		add("Aperture", "f/"+strconv.FormatFloat(md.FNumber, 'f', -1, 64))
+	}
+	if md.ISO > 0 {
+		add("ISO", strconv.Itoa(md.ISO))
+	}
+	if md.FocalLength > 0 {
+		add("Focal length", strconv.FormatFloat(md.FocalLength, 'f', -1, 64)+" mm")
+	}
+
+	return data
+}
+
+// imageOrder returns the order of a gallery's images requested with the sort
+// query parameter. By default images are in the order chosen by the owner.
+func imageOrder(r *http.Request) models.ImageOrder {
+	order := models.ImageOrder(r.URL.Query().Get("sort"))
+	switch order {
+	case models.OrderUploaded, models.OrderCaptured:
+		return order
+	}
+	return models.OrderPosition
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/alexproskurov/snapfolio/models"
+)
+
+// previewBaseURL is prepended to the URLs in link previews, which must be
+// absolute.
+const previewBaseURL = "https://snapfolio.proskurov.com"
+
+// preview holds the Open Graph properties that chat apps and social networks
+// use to render a preview of a shared gallery link.
+type preview struct {
+	Title string
+	URL   string
+	// ImageURL is empty if the cover image can't be fetched without signing
+	// in or unlocking the gallery.
+	ImageURL string
+}
+
+// galleryPreview returns the preview of the gallery page. Only the covers of
+// public and unlisted galleries are included, since previews are fetched
+// without the visitor's cookies.
+func galleryPreview(gallery *models.Gallery) *preview {
+	p := preview{
+		Title: gallery.Title,
+		URL:   fmt.Sprintf("%s/galleries/%d", previewBaseURL, gallery.ID),
+	}
+	if gallery.CoverImageID == 0 {
+		return &p
+	}
+
+	imageURL := fmt.Sprintf("%s/galleries/%d/images/%d?", previewBaseURL, gallery.ID, gallery.CoverImageID)
+	vals := url.Values{
+		"size": {models.SizeMedium},
+	}
+	switch gallery.Visibility {
+	case models.VisibilityPublic:
+		p.ImageURL = imageURL + vals.Encode()
+	case models.VisibilityUnlisted:
+		vals.Set("key", gallery.AccessKey)
+		p.URL += "?" + url.Values{"key": {gallery.AccessKey}}.Encode()
+		p.ImageURL = imageURL + vals.Encode()
+	}
+
+	return &p
+}
+
+// sharedPreview returns the preview of the gallery page of a share link.
+func sharedPreview(gallery *models.Gallery, token string) *preview {
+	p := preview{
+		Title: gallery.Title,
+		URL:   previewBaseURL + "/s/" + token,
+	}
+	if gallery.CoverImageID != 0 {
+		p.ImageURL = fmt.Sprintf("%s/s/%s/images/%d?size=%s", previewBaseURL, token,
+			gallery.CoverImageID, models.SizeMedium)
+	}
+
+	return &p
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/alexproskurov/snapfolio/context"
+	"github.com/alexproskurov/snapfolio/models"
+)
+
+type Search struct {
+	Templates struct {
+		Results Template
+	}
+	SearchService *models.SearchService
+}
+
+// Results shows the galleries and images matching the q query parameter.
+// Visitors who aren't signed in only find public galleries.
+func (s Search) Results(w http.ResponseWriter, r *http.Request) {
+	type Gallery struct {
+		ID           int
+		Title        string
+		CoverImageID int
+	}
+	type Image struct {
+		ID        int
+		GalleryID int
+		Filename  string
+		Title     string
+		Caption   string
+		AltText   string
+		URL       string
+	}
+	var data struct {
+		Query     string
+		Galleries []Gallery
+		Images    []Image
+		Page      int
+		PrevPage  int
+		NextPage  int
+	}
+	data.Query = r.URL.Query().Get("q")
+	data.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if data.Page < 1 {
+		data.Page = 1
+	}
+
+	userID := searchUserID(r)
+	opts := models.ListOptions{
+		Page:    data.Page,
+		PerPage: models.DefaultSearchResultsPerPage,
+	}
+	galleries, err := s.SearchService.Galleries(userID, data.Query, opts)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	images, err := s.SearchService.Images(userID, data.Query, opts)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	for _, gallery := range galleries {
+		data.Galleries = append(data.Galleries, Gallery{
+			ID:           gallery.ID,
+			Title:        gallery.Title,
+			CoverImageID: gallery.CoverImageID,
+		})
+	}
+	for _, image := range images {
+		data.Images = append(data.Images, Image{
+			ID:        image.ID,
+			GalleryID: image.GalleryID,
+			Filename:  image.OriginalFilename,
+			Title:     image.Title,
+			Caption:   image.Caption,
+			AltText:   image.AltText,
+			URL:       fmt.Sprintf("/galleries/%d/images/%d", image.GalleryID, image.ID),
+		})
+	}
+
+	if data.Page > 1 {
+		data.PrevPage = data.Page - 1
+	}
+	// Results aren't counted, so there may be a next page whenever either
+	// list is full.
+	if len(galleries) == opts.PerPage || len(images) == opts.PerPage {
+		data.NextPage = data.Page + 1
+	}
+	s.Templates.Results.Execute(w, r, data)
+}
+
+// searchUserID returns the ID of the signed in user, or 0 for visitors.
+func searchUserID(r *http.Request) int {
+	user := context.User(r.Context())
+	if user == nil {
+		return 0
+	}
+	return user.ID
+}
@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexproskurov/snapfolio/errors"
+	"github.com/alexproskurov/snapfolio/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// ShareLinkDurations are the lifetimes that can be chosen for new share
+// links, keyed by the number of days.
+var ShareLinkDurations = map[int]time.Duration{
+	1:  24 * time.Hour,
+	7:  7 * 24 * time.Hour,
+	30: 30 * 24 * time.Hour,
+	90: 90 * 24 * time.Hour,
+}
+
+func (g Gallery) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, userMustOwnGallery)
+	if err != nil {
+		return
+	}
+
+	days, _ := strconv.Atoi(r.FormValue("days"))
+	duration, ok := ShareLinkDurations[days]
+	if !ok {
+		http.Error(w, "Invalid expiry.", http.StatusBadRequest)
+		return
+	}
+	allowDownload := r.FormValue("allow_download") == "true"
+
+	link, err := g.ShareLinkService.Create(gallery.ID, time.Now().Add(duration), allowDownload)
+	if err != nil {
+		log.Println(err)
+		err = errors.Public(err, "Unable to create the share link. Please try again later.")
+		g.renderEdit(w, r, gallery, "", err)
+		return
+	}
+
+	g.renderEdit(w, r, gallery, previewBaseURL+"/s/"+link.Token)
+}
+
+func (g Gallery) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.getGalleryByID(w, r, userMustOwnGallery)
+	if err != nil {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "linkID"))
+	if err != nil {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+		return
+	}
+
+	err = g.ShareLinkService.Revoke(gallery.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Share link not found.", http.StatusNotFound)
+			return
+		}
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// Shared shows the gallery of the share link in the token URL parameter.
+func (g Gallery) Shared(w http.ResponseWriter, r *http.Request) {
+	link, gallery, err := g.getSharedGallery(w, r)
+	if err != nil {
+		return
+	}
+
+	err = g.ShareLinkService.RecordView(link.ID)
+	if err != nil {
+		log.Println(err)
+	}
+
+	type Image struct {
+		ID          int
+		GalleryID   int
+		Filename    string
+		Title       string
+		Caption     string
+		AltText     string
+		URL         string
+		DownloadURL string
+	}
+	var data struct {
+		ID          int
+		Title       string
+		Description string
+		Images      []Image
+		DownloadURL string
+		Sort        string
+		Preview     *preview
+	}
+	data.ID = gallery.ID
+	data.Title = gallery.Title
+	data.Description = gallery.Description
+	token := chi.URLParam(r, "token")
+	data.Preview = sharedPreview(gallery, token)
+	if link.AllowDownload {
+		data.DownloadURL = fmt.Sprintf("/s/%s/download", token)
+	}
+	order := imageOrder(r)
+	data.Sort = string(order)
+	images, err := g.ImageService.ByGalleryID(gallery.ID, order)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	for _, image := range images {
+		img := Image{
+			ID:        image.ID,
+			GalleryID: image.GalleryID,
+			Filename:  image.OriginalFilename,
+			Title:     image.Title,
+			Caption:   image.Caption,
+			AltText:   image.AltText,
+			URL:       fmt.Sprintf("/s/%s/images/%d", token, image.ID),
+		}
+		if link.AllowDownload {
+			img.DownloadURL = img.URL + "?download=true"
+		}
+		data.Images = append(data.Images, img)
+	}
+
+	g.Templates.Show.Execute(w, r, data)
+}
+
+// SharedImage serves an image of the share link's gallery. Unless the link
+// allows downloads, only resized variants are served.
+func (g Gallery) SharedImage(w http.ResponseWriter, r *http.Request) {
+	link, gallery, err := g.getSharedGallery(w, r)
+	if err != nil {
+		return
+	}
+	image, err := g.getImageByID(w, r, gallery.ID)
+	if err != nil {
+		return
+	}
+
+	if r.URL.Query().Get("download") == "true" {
+		if !link.AllowDownload {
+			http.Error(w, "Downloads are not allowed for this link.", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": image.OriginalFilename,
+		}))
+		g.serveOriginal(w, r, image)
+		return
+	}
+
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = models.SizeLarge
+	}
+	if _, ok := models.VariantWidths[size]; !ok {
+		http.Error(w, "Invalid image size.", http.StatusBadRequest)
+		return
+	}
+	err = g.serveVariant(w, r, image, size)
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, models.ErrNotFound) {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if link.AllowDownload {
+		g.serveOriginal(w, r, image)
+		return
+	}
+	http.Error(w, "This image is still being processed. Try again shortly.", http.StatusNotFound)
+}
+
+// getSharedGallery looks up the share link identified by the token URL
+// parameter and its gallery. If the link can't be used, an error response is
+// written and an error is returned.
+func (g Gallery) getSharedGallery(w http.ResponseWriter, r *http.Request) (*models.ShareLink, *models.Gallery, error) {
+	link, err := g.ShareLinkService.ByToken(chi.URLParam(r, "token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNotFound):
+			http.Error(w, "This link is invalid or has been revoked.", http.StatusNotFound)
+		case errors.Is(err, models.ErrTokenExpired):
+			http.Error(w, "This link has expired.", http.StatusGone)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, nil, err
+	}
+
+	gallery, err := g.GalleryService.GetByID(link.GalleryID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "This link is invalid or has been revoked.", http.StatusNotFound)
+			return nil, nil, err
+		}
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return nil, nil, err
+	}
+
+	return link, gallery, nil
+}
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/alexproskurov/snapfolio/context"
+	"github.com/alexproskurov/snapfolio/errors"
+	"github.com/alexproskurov/snapfolio/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// Tag lists the signed in user's galleries and images with a tag. Only the
+// first page of galleries is shown, the rest are on the gallery list
+// filtered by the tag.
+func (g Gallery) Tag(w http.ResponseWriter, r *http.Request) {
+	type Gallery struct {
+		ID           int
+		Title        string
+		CoverImageID int
+	}
+	type Image struct {
+		ID        int
+		GalleryID int
+		Filename  string
+		Title     string
+		AltText   string
+		URL       string
+	}
+	var data struct {
+		Tag          string
+		Galleries    []Gallery
+		GalleryCount int
+		Images       []Image
+		// Page, TotalPages, PrevPage and NextPage paginate the images.
+		Page       int
+		TotalPages int
+		PrevPage   int
+		NextPage   int
+	}
+	data.Tag = chi.URLParam(r, "tag")
+	data.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if data.Page < 1 {
+		data.Page = 1
+	}
+
+	userID := context.User(r.Context()).ID
+	var err error
+	data.GalleryCount, err = g.GalleryService.CountByUserID(userID, data.Tag)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	galleries, err := g.GalleryService.GetByUserID(userID, models.ListOptions{
+		PerPage: models.DefaultGalleriesPerPage,
+		Tag:     data.Tag,
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	for _, gallery := range galleries {
+		data.Galleries = append(data.Galleries, Gallery{
+			ID:           gallery.ID,
+			Title:        gallery.Title,
+			CoverImageID: gallery.CoverImageID,
+		})
+	}
+
+	total, err := g.ImageService.CountByTag(userID, data.Tag)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	perPage := models.DefaultTaggedImagesPerPage
+	data.TotalPages = (total + perPage - 1) / perPage
+	if data.Page > 1 {
+		data.PrevPage = data.Page - 1
+	}
+	if data.Page < data.TotalPages {
+		data.NextPage = data.Page + 1
+	}
+	images, err := g.ImageService.ByTag(userID, data.Tag, models.ListOptions{
+		Page:    data.Page,
+		PerPage: perPage,
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	for _, image := range images {
+		data.Images = append(data.Images, Image{
+			ID:        image.ID,
+			GalleryID: image.GalleryID,
+			Filename:  image.OriginalFilename,
+			Title:     image.Title,
+			AltText:   image.AltText,
+			URL:       fmt.Sprintf("/galleries/%d/images/%d", image.GalleryID, image.ID),
+		})
+	}
+
+	g.Templates.Tag.Execute(w, r, data)
+}
+
+// tagErrorMessage returns the message shown when tags are rejected with
+// models.ErrInvalidTag or models.ErrTooManyTags, or "" for other errors.
+func tagErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, models.ErrInvalidTag):
+		return fmt.Sprintf(
+			"Tags can be at most %d characters long and may only contain letters, digits, spaces, hyphens and underscores.",
+			models.MaxTagLength)
+	case errors.Is(err, models.ErrTooManyTags):
+		return fmt.Sprintf("At most %d tags are allowed.", models.MaxTags)
+	}
+	return ""
+}
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexproskurov/snapfolio/context"
+	"github.com/alexproskurov/snapfolio/errors"
+	"github.com/alexproskurov/snapfolio/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// Trash lists the galleries and images the user deleted, which can be
+// restored until they are purged.
+func (g Gallery) Trash(w http.ResponseWriter, r *http.Request) {
+	g.renderTrash(w, r)
+}
+
+func (g Gallery) renderTrash(w http.ResponseWriter, r *http.Request, errs ...error) {
+	type Gallery struct {
+		ID        int
+		Title     string
+		DeletedAt time.Time
+		PurgeAt   time.Time
+	}
+	type Image struct {
+		ID           int
+		GalleryID    int
+		Filename     string
+		Title        string
+		GalleryTitle string
+		DeletedAt    time.Time
+		PurgeAt      time.Time
+	}
+	var data struct {
+		Galleries []Gallery
+		Images    []Image
+	}
+
+	userID := context.User(r.Context()).ID
+	galleries, err := g.TrashService.Galleries(userID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	images, err := g.TrashService.Images(userID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	for _, gallery := range galleries {
+		data.Galleries = append(data.Galleries, Gallery{
+			ID:        gallery.ID,
+			Title:     gallery.Title,
+			DeletedAt: gallery.DeletedAt,
+			PurgeAt:   g.TrashService.PurgeAt(gallery.DeletedAt),
+		})
+	}
+	for _, image := range images {
+		data.Images = append(data.Images, Image{
+			ID:           image.ID,
+			GalleryID:    image.GalleryID,
+			Filename:     image.OriginalFilename,
+			Title:        image.Title,
+			GalleryTitle: image.GalleryTitle,
+			DeletedAt:    image.DeletedAt,
+			PurgeAt:      g.TrashService.PurgeAt(image.DeletedAt),
+		})
+	}
+
+	g.Templates.Trash.Execute(w, r, data, errs...)
+}
+
+func (g Gallery) RestoreGallery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+		return
+	}
+
+	userID := context.User(r.Context()).ID
+	err = g.TrashService.RestoreGallery(userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			err = errors.Public(err, "That gallery is no longer in the trash.")
+		} else {
+			log.Println(err)
+			err = errors.Public(err, "Unable to restore the gallery. Please try again later.")
+		}
+		g.renderTrash(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/galleries/%d/edit", id), http.StatusFound)
+}
+
+func (g Gallery) RestoreImage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+		return
+	}
+
+	userID := context.User(r.Context()).ID
+	err = g.TrashService.RestoreImage(userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			err = errors.Public(err, "That image is no longer in the trash.")
+		} else {
+			log.Println(err)
+			err = errors.Public(err, "Unable to restore the image. Please try again later.")
+		}
+		g.renderTrash(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusFound)
+}
@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/alexproskurov/snapfolio/context"
+	"github.com/alexproskurov/snapfolio/errors"
+	"github.com/alexproskurov/snapfolio/models"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// TwoFactor asks for the second factor of a sign in that was started by
+// ProcessSignIn.
+func (u User) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, err := readCookie(r, CookiePendingSignIn)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	u.Templates.TwoFactor.Execute(w, r, nil)
+}
+
+func (u User) ProcessTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Email string
+	}
+	token, err := readCookie(r, CookiePendingSignIn)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+
+	userID, err := u.TwoFactorService.CompletePending(token, r.FormValue("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidCode):
+			err = errors.Public(err, "That code is not valid. Please try again.")
+			u.Templates.TwoFactor.Execute(w, r, nil, err)
+		case errors.Is(err, models.ErrTokenExpired):
+			deleteCookie(w, CookiePendingSignIn)
+			err = errors.Public(err, "Your sign in has expired. Please sign in again.")
+			u.Templates.SignIn.Execute(w, r, data, err)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	deleteCookie(w, CookiePendingSignIn)
+	err = u.signIn(w, r, userID)
+	if err != nil {
+		err = errors.Public(err, "Unable to sign in. Please try again later.")
+		u.Templates.SignIn.Execute(w, r, data, err)
+		return
+	}
+
+	http.Redirect(w, r, "/galleries", http.StatusFound)
+}
+
+type twoFactorData struct {
+	Enabled bool
+	// Secret and QRCode are set while the user is setting up their
+	// authenticator app.
+	Secret string
+	QRCode template.URL
+	// RecoveryCodes are only set right after two-factor authentication has
+	// been enabled.
+	RecoveryCodes []string
+}
+
+func (u User) TwoFactorSettings(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	data := twoFactorData{
+		Enabled: user.TwoFactorEnabled,
+	}
+	if !data.Enabled {
+		err := u.addEnrollment(&data, user)
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			log.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	u.Templates.TwoFactorSettings.Execute(w, r, data)
+}
+
+func (u User) ProcessTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	enrollment, err := u.TwoFactorService.Enroll(user)
+	if err != nil {
+		if errors.Is(err, models.ErrTwoFactorEnabled) {
+			http.Redirect(w, r, "/users/2fa", http.StatusFound)
+			return
+		}
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	var data twoFactorData
+	err = setEnrollment(&data, enrollment)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	u.Templates.TwoFactorSettings.Execute(w, r, data)
+}
+
+func (u User) ProcessTwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	codes, err := u.TwoFactorService.Enable(user.ID, r.FormValue("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTwoFactorEnabled):
+			http.Redirect(w, r, "/users/2fa", http.StatusFound)
+		case errors.Is(err, models.ErrInvalidCode):
+			var data twoFactorData
+			enrollErr := u.addEnrollment(&data, user)
+			if enrollErr != nil && !errors.Is(enrollErr, models.ErrNotFound) {
+				log.Println(enrollErr)
+				http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+				return
+			}
+			err = errors.Public(err, "That code is not valid. Make sure the time on your phone is correct and try again.")
+			u.Templates.TwoFactorSettings.Execute(w, r, data, err)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	data := twoFactorData{
+		Enabled:       true,
+		RecoveryCodes: codes,
+	}
+	u.Templates.TwoFactorSettings.Execute(w, r, data)
+}
+
+// ProcessTwoFactorDisable turns off two-factor authentication. A current code
+// is required so that someone with access to a signed in device can't quietly
+// remove the second factor.
+func (u User) ProcessTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	err := u.TwoFactorService.Verify(user.ID, r.FormValue("code"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCode) {
+			data := twoFactorData{
+				Enabled: user.TwoFactorEnabled,
+			}
+			err = errors.Public(err, "That code is not valid. Please try again.")
+			u.Templates.TwoFactorSettings.Execute(w, r, data, err)
+			return
+		}
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	err = u.TwoFactorService.Disable(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/users/2fa", http.StatusFound)
+}
+
+// startTwoFactor begins a sign in that has to be completed by entering a
+// code on the /signin/2fa page.
+func (u User) startTwoFactor(w http.ResponseWriter, userID int) error {
+	pending, err := u.TwoFactorService.CreatePending(userID)
+	if err != nil {
+		return err
+	}
+
+	cookie := newCookie(CookiePendingSignIn, pending.Token)
+	cookie.Expires = pending.ExpiresAt
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// addEnrollment adds the user's unconfirmed enrollment, if any, to data.
+func (u User) addEnrollment(data *twoFactorData, user *models.User) error {
+	enrollment, err := u.TwoFactorService.Enrollment(user)
+	if err != nil {
+		return err
+	}
+	return setEnrollment(data, enrollment)
+}
+
+func setEnrollment(data *twoFactorData, enrollment *models.TOTPEnrollment) error {
+	png, err := qrcode.Encode(enrollment.URI, qrcode.Medium, 256)
+	if err != nil {
+		return err
+	}
+
+	data.Secret = enrollment.Secret
+	// The QR code is a PNG generated from the secret, so it is safe to
+	// render as a data URL.
+	data.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	return nil
+}
@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"net/url"
+	"net/mail"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alexproskurov/snapfolio/context"
 	"github.com/alexproskurov/snapfolio/errors"
 	"github.com/alexproskurov/snapfolio/models"
+	"github.com/go-chi/chi/v5"
 )
 
 type User struct {
@@ -20,11 +23,22 @@ type User struct {
 		CheckYourEmail Template
 		ResetPassword  Template
 		ChangeEmail    Template
+		VerifyEmail    Template
+		ConfirmEmail   Template
+		Devices        Template
+		// TwoFactor asks for the second factor while signing in, and
+		// TwoFactorSettings lets users set up two-factor authentication.
+		TwoFactor         Template
+		TwoFactorSettings Template
+		AccessTokens      Template
 	}
-	UserService          *models.UserService
-	SessionService       *models.SessionService
-	PasswordResetService *models.PasswordResetService
-	EmailService         *models.EmailService
+	UserService              *models.UserService
+	SessionService           *models.SessionService
+	PasswordResetService     *models.PasswordResetService
+	EmailVerificationService *models.EmailVerificationService
+	EmailService             *models.EmailService
+	TwoFactorService         *models.TwoFactorService
+	AccessTokenService       *models.AccessTokenService
 }
 
 func (u User) New(w http.ResponseWriter, r *http.Request) {
@@ -40,8 +54,14 @@ func (u User) Create(w http.ResponseWriter, r *http.Request) {
 		Email    string
 		Password string
 	}
-	data.Email = r.FormValue("email")
+	data.Email = strings.ToLower(strings.TrimSpace(r.FormValue("email")))
 	data.Password = r.FormValue("password")
+	if !validEmail(data.Email) {
+		err := fmt.Errorf("invalid email address %q", data.Email)
+		err = errors.Public(err, "Please enter a valid email address.")
+		u.Templates.New.Execute(w, r, data, err)
+		return
+	}
 
 	user, err := u.UserService.Create(data.Email, data.Password)
 	if err != nil {
@@ -52,14 +72,21 @@ func (u User) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session, err := u.SessionService.Create(user.ID)
+	// The account is usable right away, so a failure to send the
+	// verification email shouldn't stop the user from signing in. They can
+	// ask for another one later.
+	err = u.sendVerification(user.ID, user.Email)
+	if err != nil {
+		log.Println(err)
+	}
+
+	err = u.signIn(w, r, user.ID)
 	if err != nil {
 		err = errors.Public(err, "Unable to sign in. Please try again later.")
 		u.Templates.SignIn.Execute(w, r, data, err)
 		return
 	}
 
-	setCookie(w, CookieSession, session.Token)
 	http.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
@@ -85,20 +112,35 @@ func (u User) ProcessSignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session, err := u.SessionService.Create(user.ID)
+	// The session is only created once the user has also entered a code
+	// from their authenticator app.
+	if user.TwoFactorEnabled {
+		err = u.startTwoFactor(w, user.ID)
+		if err != nil {
+			err = errors.Public(err, "Unable to sign in. Please try again later.")
+			u.Templates.SignIn.Execute(w, r, data, err)
+			return
+		}
+		http.Redirect(w, r, "/signin/2fa", http.StatusFound)
+		return
+	}
+
+	err = u.signIn(w, r, user.ID)
 	if err != nil {
 		err = errors.Public(err, "Unable to sign in. Please try again later.")
 		u.Templates.SignIn.Execute(w, r, data, err)
 		return
 	}
 
-	setCookie(w, CookieSession, session.Token)
 	http.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
 func (u User) CurrentUser(w http.ResponseWriter, r *http.Request) {
 	user := context.User(r.Context())
 	fmt.Fprintf(w, "Current User: %s\n", user.Email)
+	if !user.EmailVerified {
+		fmt.Fprintln(w, "Your email address has not been verified yet.")
+	}
 }
 
 func (u User) ProcessSignOut(w http.ResponseWriter, r *http.Request) {
@@ -144,11 +186,7 @@ func (u User) ProcessForgotPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	vals := url.Values{
-		"token": {pwReset.Token},
-	}
-	resetURL := "https://snapfolio.proskurov.com/reset-pw?" + vals.Encode()
-	err = u.EmailService.ForgotPassword(data.Email, resetURL)
+	err = u.EmailService.ForgotPassword(pwReset)
 	if err != nil {
 		err = errors.Public(err, "Something went wrong. Try again later.")
 		u.Templates.ForgotPassword.Execute(w, r, data, err)
@@ -193,16 +231,40 @@ func (u User) ProcessResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Whoever knew the old password may still be signed in, so sign out
+	// every device before signing the user in again.
+	err = u.SessionService.DeleteByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+	}
+
+	// Resetting the password only proves access to the email account, so
+	// the second factor is still required.
+	twoFactor, err := u.TwoFactorService.Enabled(user.ID)
+	if err != nil {
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if twoFactor {
+		err = u.startTwoFactor(w, user.ID)
+		if err != nil {
+			err = errors.Public(err, "Unable to sign in. Please try again later.")
+			u.Templates.SignIn.Execute(w, r, data, err)
+			return
+		}
+		http.Redirect(w, r, "/signin/2fa", http.StatusFound)
+		return
+	}
+
 	// Sign the user is now that their password has been reset.
 	// Any errors from this point onwards should redirect the user
 	// to the sign in page.
-	session, err := u.SessionService.Create(user.ID)
+	err = u.signIn(w, r, user.ID)
 	if err != nil {
 		err = errors.Public(err, "Unable to sign in. Please try again later.")
 		u.Templates.SignIn.Execute(w, r, data, err)
 		return
 	}
-	setCookie(w, CookieSession, session.Token)
 	http.Redirect(w, r, "/users/me", http.StatusFound)
 }
 
@@ -218,28 +280,206 @@ func (u User) ProcessChangeEmail(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Email string
 	}
-	data.Email = r.FormValue("email")
+	data.Email = strings.ToLower(strings.TrimSpace(r.FormValue("email")))
 	user := context.User(r.Context())
+	if data.Email == user.Email {
+		http.Redirect(w, r, "/users/me", http.StatusFound)
+		return
+	}
+	if !validEmail(data.Email) {
+		err := fmt.Errorf("invalid email address %q", data.Email)
+		err = errors.Public(err, "Please enter a valid email address.")
+		u.Templates.ChangeEmail.Execute(w, r, data, err)
+		return
+	}
 
-	err := u.UserService.UpdateEmail(user.ID, data.Email)
+	// The current email address stays active until the user confirms they
+	// have access to the new one.
+	err := u.sendVerification(user.ID, data.Email)
 	if err != nil {
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		if errors.Is(err, models.ErrEmailTaken) {
+			err = errors.Public(err, "That email address is already associated with an account.")
+		} else {
+			err = errors.Public(err, "Something went wrong. Try again later.")
+		}
+		u.Templates.ChangeEmail.Execute(w, r, data, err)
 		return
 	}
 
-	// Sign the user is now that their email has been updated.
-	// Any errors from this point onwards should redirect the user
-	// to the sign in page.
-	session, err := u.SessionService.Create(user.ID)
+	u.Templates.VerifyEmail.Execute(w, r, data)
+}
+
+// ProcessResendVerification sends a new verification email for the user's
+// current email address.
+func (u User) ProcessResendVerification(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Email string
+	}
+	user := context.User(r.Context())
+	data.Email = user.Email
+	if user.EmailVerified {
+		http.Redirect(w, r, "/users/me", http.StatusFound)
+		return
+	}
+
+	err := u.sendVerification(user.ID, data.Email)
 	if err != nil {
-		err = errors.Public(err, "Unable to sign in. Please try again later.")
+		err = errors.Public(err, "Something went wrong. Try again later.")
+		u.Templates.ChangeEmail.Execute(w, r, data, err)
+		return
+	}
+
+	u.Templates.VerifyEmail.Execute(w, r, data)
+}
+
+// validEmail reports whether email is a bare email address, without a
+// display name or angle brackets.
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// ConfirmEmail shows the page that the link in verification emails leads to.
+// Following a link doesn't confirm the address, as links are also opened by
+// mail scanners and link previews, and GET requests must not change state.
+func (u User) ConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Token string
+	}
+	data.Token = r.FormValue("token")
+	u.Templates.ConfirmEmail.Execute(w, r, data)
+}
+
+func (u User) ProcessConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Email string
+	}
+	token := r.FormValue("token")
+
+	user, err := u.EmailVerificationService.Consume(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNotFound):
+			err = errors.Public(err, "This confirmation link is invalid or has already been used.")
+		case errors.Is(err, models.ErrTokenExpired):
+			err = errors.Public(err, "This confirmation link has expired. Please request a new one.")
+		case errors.Is(err, models.ErrEmailTaken):
+			err = errors.Public(err, "That email address is already associated with an account.")
+		default:
+			log.Println(err)
+			err = errors.Public(err, "Something went wrong. Try again later.")
+		}
+		if currentUser := context.User(r.Context()); currentUser != nil {
+			data.Email = currentUser.Email
+			u.Templates.ChangeEmail.Execute(w, r, data, err)
+			return
+		}
 		u.Templates.SignIn.Execute(w, r, data, err)
 		return
 	}
-	setCookie(w, CookieSession, session.Token)
+
+	if context.User(r.Context()) == nil {
+		data.Email = user.Email
+		u.Templates.SignIn.Execute(w, r, data)
+		return
+	}
 	http.Redirect(w, r, "/users/me", http.StatusFound)
 }
 
+func (u User) Devices(w http.ResponseWriter, r *http.Request) {
+	type Device struct {
+		ID          int
+		Description string
+		IPAddress   string
+		CreatedAt   time.Time
+		LastSeenAt  time.Time
+		Current     bool
+	}
+	var data struct {
+		Devices []Device
+	}
+
+	user := context.User(r.Context())
+	sessions, err := u.SessionService.ByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	token, _ := readCookie(r, CookieSession)
+	currentHash := u.SessionService.Hash(token)
+	for _, session := range sessions {
+		data.Devices = append(data.Devices, Device{
+			ID:          session.ID,
+			Description: describeUserAgent(session.UserAgent),
+			IPAddress:   session.IPAddress,
+			CreatedAt:   session.CreatedAt,
+			LastSeenAt:  session.LastSeenAt,
+			Current:     session.TokenHash == currentHash,
+		})
+	}
+
+	u.Templates.Devices.Execute(w, r, data)
+}
+
+func (u User) ProcessRevokeDevice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+		return
+	}
+
+	user := context.User(r.Context())
+	err = u.SessionService.DeleteByID(user.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Device not found.", http.StatusNotFound)
+			return
+		}
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/users/sessions", http.StatusFound)
+}
+
+func (u User) ProcessSignOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	err := u.SessionService.DeleteByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	deleteCookie(w, CookieSession)
+	http.Redirect(w, r, "/signin", http.StatusFound)
+}
+
+// sendVerification emails a link the user can follow to confirm that they own
+// the email address.
+func (u User) sendVerification(userID int, email string) error {
+	verification, err := u.EmailVerificationService.Create(userID, email)
+	if err != nil {
+		return err
+	}
+
+	return u.EmailService.VerifyEmail(verification)
+}
+
+// signIn creates a new session for the user on the device making the request
+// and sets the session cookie.
+func (u User) signIn(w http.ResponseWriter, r *http.Request, userID int) error {
+	session, err := u.SessionService.Create(userID, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return err
+	}
+
+	setSessionCookie(w, session)
+	return nil
+}
+
 type UserMiddleware struct {
 	SessionService *models.SessionService
 }
@@ -258,6 +498,14 @@ func (umw UserMiddleware) SetUser(next http.Handler) http.Handler {
 			return
 		}
 
+		session, err := umw.SessionService.Rotate(token)
+		if err != nil {
+			log.Println(err)
+		}
+		if session != nil {
+			setSessionCookie(w, session)
+		}
+
 		ctx := r.Context()
 		ctx = context.WithUser(ctx, user)
 		r = r.WithContext(ctx)
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries DROP CONSTRAINT galleries_user_id_key;
+CREATE INDEX galleries_user_id_idx ON galleries (user_id, id DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX galleries_user_id_idx;
+ALTER TABLE galleries ADD CONSTRAINT galleries_user_id_key UNIQUE (user_id);
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE sessions DROP CONSTRAINT sessions_user_id_key;
+ALTER TABLE sessions
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX sessions_user_id_idx;
+-- Keep only the most recently used session of each user.
+DELETE FROM sessions
+WHERE id NOT IN (
+    SELECT DISTINCT ON (user_id) id
+    FROM sessions
+    ORDER BY user_id, last_seen_at DESC
+);
+ALTER TABLE sessions
+    DROP COLUMN created_at,
+    DROP COLUMN last_seen_at,
+    DROP COLUMN user_agent,
+    DROP COLUMN ip_address;
+ALTER TABLE sessions ADD CONSTRAINT sessions_user_id_key UNIQUE (user_id);
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE sessions
+    ADD COLUMN rotated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN previous_token_hash TEXT;
+CREATE INDEX sessions_previous_token_hash_idx ON sessions (previous_token_hash);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX sessions_previous_token_hash_idx;
+ALTER TABLE sessions
+    DROP COLUMN rotated_at,
+    DROP COLUMN previous_token_hash;
+-- +goose StatementEnd
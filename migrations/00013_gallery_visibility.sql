@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+    ADD COLUMN visibility TEXT NOT NULL DEFAULT 'private'
+        CHECK (visibility IN ('private', 'unlisted', 'public', 'password')),
+    ADD COLUMN password_hash TEXT,
+    ADD COLUMN access_key TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries
+    DROP COLUMN visibility,
+    DROP COLUMN password_hash,
+    DROP COLUMN access_key;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE share_links (
+    id SERIAL PRIMARY KEY,
+    gallery_id INT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    allow_download BOOLEAN NOT NULL DEFAULT false,
+    view_count INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    FOREIGN KEY (gallery_id) REFERENCES galleries(id)
+        ON DELETE CASCADE
+);
+CREATE INDEX share_links_gallery_id_idx ON share_links (gallery_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE share_links;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE images
+    ADD COLUMN captured_at TIMESTAMPTZ,
+    ADD COLUMN camera TEXT NOT NULL DEFAULT '',
+    ADD COLUMN lens TEXT NOT NULL DEFAULT '',
+    ADD COLUMN exposure_time TEXT NOT NULL DEFAULT '',
+    ADD COLUMN f_number DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN iso INT NOT NULL DEFAULT 0,
+    ADD COLUMN focal_length DOUBLE PRECISION NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images
+    DROP COLUMN captured_at,
+    DROP COLUMN camera,
+    DROP COLUMN lens,
+    DROP COLUMN exposure_time,
+    DROP COLUMN f_number,
+    DROP COLUMN iso,
+    DROP COLUMN focal_length;
+-- +goose StatementEnd
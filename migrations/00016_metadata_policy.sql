@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+    ADD COLUMN metadata_policy TEXT NOT NULL DEFAULT 'strip'
+        CHECK (metadata_policy IN ('strip', 'strip_keep_original', 'keep'));
+ALTER TABLE images
+    ADD COLUMN original_kept BOOLEAN NOT NULL DEFAULT false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images
+    DROP COLUMN original_kept;
+ALTER TABLE galleries
+    DROP COLUMN metadata_policy;
+-- +goose StatementEnd
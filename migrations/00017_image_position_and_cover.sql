@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE images
+    ADD COLUMN position INT NOT NULL DEFAULT 0;
+UPDATE images
+SET position = ordered.position
+FROM (
+    SELECT id, row_number() OVER (PARTITION BY gallery_id ORDER BY created_at, id) AS position
+    FROM images
+) AS ordered
+WHERE images.id = ordered.id;
+CREATE INDEX images_gallery_id_position_idx ON images (gallery_id, position);
+ALTER TABLE galleries
+    ADD COLUMN cover_image_id INT REFERENCES images(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries
+    DROP COLUMN cover_image_id;
+ALTER TABLE images
+    DROP COLUMN position;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE gallery_tags (
+    gallery_id INT NOT NULL,
+    tag TEXT NOT NULL,
+    PRIMARY KEY (gallery_id, tag),
+    FOREIGN KEY (gallery_id) REFERENCES galleries(id)
+        ON DELETE CASCADE
+);
+CREATE INDEX gallery_tags_tag_idx ON gallery_tags (tag);
+CREATE TABLE image_tags (
+    image_id INT NOT NULL,
+    tag TEXT NOT NULL,
+    PRIMARY KEY (image_id, tag),
+    FOREIGN KEY (image_id) REFERENCES images(id)
+        ON DELETE CASCADE
+);
+CREATE INDEX image_tags_tag_idx ON image_tags (tag);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE image_tags;
+DROP TABLE gallery_tags;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', COALESCE(title, '')), 'A') ||
+        setweight(to_tsvector('english', description), 'B')
+    ) STORED;
+CREATE INDEX galleries_search_vector_idx ON galleries USING GIN (search_vector);
+ALTER TABLE images
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', title), 'A') ||
+        setweight(to_tsvector('english', caption), 'B') ||
+        setweight(to_tsvector('simple', regexp_replace(filename, '[._-]+', ' ', 'g')), 'C')
+    ) STORED;
+CREATE INDEX images_search_vector_idx ON images USING GIN (search_vector);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images DROP COLUMN search_vector;
+ALTER TABLE galleries DROP COLUMN search_vector;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE images
+    ADD COLUMN original_filename TEXT NOT NULL DEFAULT '';
+UPDATE images
+SET original_filename = filename;
+ALTER TABLE images DROP COLUMN search_vector;
+ALTER TABLE images
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', title), 'A') ||
+        setweight(to_tsvector('english', caption), 'B') ||
+        setweight(to_tsvector('simple', regexp_replace(original_filename, '[._-]+', ' ', 'g')), 'C')
+    ) STORED;
+CREATE INDEX images_search_vector_idx ON images USING GIN (search_vector);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images DROP COLUMN search_vector;
+ALTER TABLE images
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', title), 'A') ||
+        setweight(to_tsvector('english', caption), 'B') ||
+        setweight(to_tsvector('simple', regexp_replace(filename, '[._-]+', ' ', 'g')), 'C')
+    ) STORED;
+CREATE INDEX images_search_vector_idx ON images USING GIN (search_vector);
+ALTER TABLE images DROP COLUMN original_filename;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Password reset and verification emails used to be queued with their links,
+-- tokens included. Failed jobs are kept, so remove the ones that may still
+-- hold a token.
+DELETE FROM jobs
+WHERE kind = 'send_email' AND status = 'failed';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 1;
+-- +goose StatementEnd
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxAccessTokenNameLength is the maximum number of characters allowed in
+	// the name of an access token.
+	MaxAccessTokenNameLength = 100
+	// accessTokenPrefix makes access tokens recognizable, e.g. by secret
+	// scanners, if they end up somewhere they shouldn't.
+	accessTokenPrefix = "sfpat_"
+)
+
+// AccessToken is a personal access token that authenticates API requests as
+// the user who created it.
+type AccessToken struct {
+	ID     int
+	UserID int
+	// Name helps the user remember what the token is used for.
+	Name string
+	// Token is only set when creating a new access token, as only the hash
+	// of the token is stored in the database.
+	Token     string
+	TokenHash string
+	CreatedAt time.Time
+	// LastUsedAt is nil if the token has never been used.
+	LastUsedAt *time.Time
+}
+
+type AccessTokenService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+}
+
+// Create creates a new access token for the user. The token is returned as
+// the Token field and cannot be retrieved again later.
+func (s *AccessTokenService) Create(userID int, name string) (*AccessToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrNameRequired
+	}
+	if len([]rune(name)) > MaxAccessTokenNameLength {
+		return nil, ErrNameTooLong
+	}
+
+	token, tokenHash, err := s.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("create access token: %w", err)
+	}
+	accessToken := AccessToken{
+		UserID:    userID,
+		Name:      name,
+		Token:     accessTokenPrefix + token,
+		TokenHash: tokenHash,
+	}
+
+	row := s.DB.QueryRow(`
+		INSERT INTO access_tokens (user_id, name, token_hash)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at;`, accessToken.UserID, accessToken.Name,
+		accessToken.TokenHash)
+	err = row.Scan(&accessToken.ID, &accessToken.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create access token: %w", err)
+	}
+
+	return &accessToken, nil
+}
+
+// User returns the user the access token belongs to and records that the
+// token has been used. It returns ErrNotFound for unknown or revoked tokens.
+func (s *AccessTokenService) User(token string) (*User, error) {
+	token, ok := strings.CutPrefix(token, accessTokenPrefix)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	var user User
+	row := s.DB.QueryRow(`
+		UPDATE access_tokens
+		SET last_used_at = now()
+		FROM users
+		WHERE access_tokens.token_hash = $1 AND users.id = access_tokens.user_id
+		RETURNING users.id, users.email, users.password_hash,
+			users.email_verified_at IS NOT NULL, users.totp_enabled_at IS NOT NULL;`,
+		s.TokenManager.Hash(token))
+	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash,
+		&user.EmailVerified, &user.TwoFactorEnabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("access token user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// ByUserID returns all of the user's access tokens, newest first.
+func (s *AccessTokenService) ByUserID(userID int) ([]AccessToken, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, name, token_hash, created_at, last_used_at
+		FROM access_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query access tokens by user id: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []AccessToken
+	for rows.Next() {
+		token := AccessToken{
+			UserID: userID,
+		}
+		err = rows.Scan(&token.ID, &token.Name, &token.TokenHash,
+			&token.CreatedAt, &token.LastUsedAt)
+		if err != nil {
+			return nil, fmt.Errorf("query access tokens by user id: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("query access tokens by user id: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// Delete revokes one of the user's access tokens. It returns ErrNotFound if
+// the user has no such token.
+func (s *AccessTokenService) Delete(userID, id int) error {
+	res, err := s.DB.Exec(`
+		DELETE FROM access_tokens
+		WHERE id = $1 AND user_id = $2;`, id, userID)
+	if err != nil {
+		return fmt.Errorf("delete access token: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete access token: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
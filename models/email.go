@@ -1,13 +1,20 @@
 package models
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 
 	"github.com/go-mail/mail/v2"
 )
 
 const (
 	DefaultSender = "support@snapfolio.com"
+	// DefaultBaseURL is where the links in emails point to when
+	// EmailService.BaseURL is not set.
+	DefaultBaseURL = "https://snapfolio.proskurov.com"
 )
 
 type Email struct {
@@ -27,6 +34,17 @@ type SMTPConfig struct {
 
 type EmailService struct {
 	Sender string
+	// BaseURL is the address of the server the links in emails point to.
+	// Defaults to DefaultBaseURL.
+	BaseURL string
+	// Jobs is used to deliver emails in the background. If not set, emails
+	// are delivered while Send is called.
+	Jobs *JobService
+	// PasswordResets and EmailVerifications issue the tokens of the links
+	// in queued password reset and verification emails. They must be set if
+	// Jobs is.
+	PasswordResets     *PasswordResetService
+	EmailVerifications *EmailVerificationService
 
 	dialer *mail.Dialer
 }
@@ -39,7 +57,33 @@ func NewEmailService(cfg SMTPConfig) *EmailService {
 	return &es
 }
 
+// Send delivers the email, or queues it for delivery if the EmailService has
+// a JobService. Queued emails are stored in the jobs table as they are, so
+// emails that contain secrets such as tokens must not be sent with Send.
 func (es *EmailService) Send(email Email) error {
+	if es.Jobs != nil {
+		err := es.Jobs.Enqueue(JobSendEmail, email)
+		if err != nil {
+			return fmt.Errorf("send: %w", err)
+		}
+		return nil
+	}
+
+	return es.deliver(email)
+}
+
+// HandleSendEmail is the JobHandler for JobSendEmail jobs.
+func (es *EmailService) HandleSendEmail(ctx context.Context, job *Job) error {
+	var email Email
+	err := json.Unmarshal(job.Payload, &email)
+	if err != nil {
+		return fmt.Errorf("send email job: %w", err)
+	}
+
+	return es.deliver(email)
+}
+
+func (es *EmailService) deliver(email Email) error {
 	msg := mail.NewMessage()
 
 	es.setFrom(msg, email)
@@ -64,9 +108,55 @@ func (es *EmailService) Send(email Email) error {
 	return nil
 }
 
-func (es *EmailService) ForgotPassword(to, resetURL string) error {
-	email := Email{
-		To:      to,
+type tokenEmailJob struct {
+	ID int
+}
+
+// ForgotPassword emails the user a link to reset their password. If the email
+// is queued, only the ID of the password reset is stored with the job and the
+// token is issued again when the email is delivered, so tokens never end up
+// in the jobs table.
+func (es *EmailService) ForgotPassword(pwReset *PasswordReset) error {
+	if es.Jobs != nil {
+		err := es.Jobs.Enqueue(JobSendPasswordReset, tokenEmailJob{ID: pwReset.ID})
+		if err != nil {
+			return fmt.Errorf("forgot password email: %w", err)
+		}
+		return nil
+	}
+
+	err := es.deliver(es.forgotPasswordEmail(pwReset))
+	if err != nil {
+		return fmt.Errorf("forgot password email: %w", err)
+	}
+
+	return nil
+}
+
+// HandleSendPasswordReset is the JobHandler for JobSendPasswordReset jobs.
+// Nothing is sent if the password reset was used or expired in the meantime.
+func (es *EmailService) HandleSendPasswordReset(ctx context.Context, job *Job) error {
+	var payload tokenEmailJob
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return fmt.Errorf("send password reset job: %w", err)
+	}
+
+	pwReset, err := es.PasswordResets.Reissue(payload.ID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("send password reset job: %w", err)
+	}
+
+	return es.deliver(es.forgotPasswordEmail(pwReset))
+}
+
+func (es *EmailService) forgotPasswordEmail(pwReset *PasswordReset) Email {
+	resetURL := es.tokenURL("/reset-pw", pwReset.Token)
+	return Email{
+		To:      pwReset.Email,
 		Subject: "Reset your password",
 		Plaintext: fmt.Sprintf(
 			"To reset your password, please visit the following link: %s",
@@ -76,15 +166,76 @@ func (es *EmailService) ForgotPassword(to, resetURL string) error {
 		please visit the following link: 
 		<a href="%s">%s</a></p>`, resetURL, resetURL),
 	}
+}
 
-	err := es.Send(email)
+// VerifyEmail emails a link the user can follow to confirm that they own the
+// email address. Like ForgotPassword, it never stores the token in the jobs
+// table.
+func (es *EmailService) VerifyEmail(verification *EmailVerification) error {
+	if es.Jobs != nil {
+		err := es.Jobs.Enqueue(JobSendEmailVerification, tokenEmailJob{ID: verification.ID})
+		if err != nil {
+			return fmt.Errorf("verify email: %w", err)
+		}
+		return nil
+	}
+
+	err := es.deliver(es.verifyEmail(verification))
 	if err != nil {
-		return fmt.Errorf("forgot password email: %w", err)
+		return fmt.Errorf("verify email: %w", err)
 	}
 
 	return nil
 }
 
+// HandleSendEmailVerification is the JobHandler for JobSendEmailVerification
+// jobs. Nothing is sent if the verification was used or expired in the
+// meantime.
+func (es *EmailService) HandleSendEmailVerification(ctx context.Context, job *Job) error {
+	var payload tokenEmailJob
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return fmt.Errorf("send email verification job: %w", err)
+	}
+
+	verification, err := es.EmailVerifications.Reissue(payload.ID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("send email verification job: %w", err)
+	}
+
+	return es.deliver(es.verifyEmail(verification))
+}
+
+func (es *EmailService) verifyEmail(verification *EmailVerification) Email {
+	verifyURL := es.tokenURL("/verify-email", verification.Token)
+	return Email{
+		To:      verification.Email,
+		Subject: "Confirm your email address",
+		Plaintext: fmt.Sprintf(
+			"To confirm your email address, please visit the following link: %s",
+			verifyURL,
+		),
+		HTML: fmt.Sprintf(`<p>To confirm your email address, 
+		please visit the following link: 
+		<a href="%s">%s</a></p>`, verifyURL, verifyURL),
+	}
+}
+
+// tokenURL returns the link to the page at path with the token in its query.
+func (es *EmailService) tokenURL(path, token string) string {
+	baseURL := es.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	vals := url.Values{
+		"token": {token},
+	}
+	return baseURL + path + "?" + vals.Encode()
+}
+
 func (es *EmailService) setFrom(msg *mail.Message, email Email) {
 	var from string
 
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	DefaultVerificationDuration = 24 * time.Hour
+)
+
+type EmailVerification struct {
+	ID     int
+	UserID int
+	// Email is the address being verified. Until the verification is
+	// consumed the user keeps their current email address.
+	Email string
+	// Token is only set when an EmailVerification is being created.
+	Token     string
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+type EmailVerificationService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+	// Duration is the amount of time that an EmailVerification is valid for.
+	// Defaults to DefaultVerificationDuration
+	Duration time.Duration
+}
+
+// Create starts the verification of an email address for the user. Any
+// verification the user had pending is replaced. It returns ErrEmailTaken if
+// another user already uses the address.
+func (evs *EmailVerificationService) Create(userID int, email string) (*EmailVerification, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	var taken bool
+	row := evs.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM users
+			WHERE email = $1 AND id <> $2
+		);`, email, userID)
+	err := row.Scan(&taken)
+	if err != nil {
+		return nil, fmt.Errorf("create email verification: %w", err)
+	}
+	if taken {
+		return nil, ErrEmailTaken
+	}
+
+	// Build the EmailVerification.
+	token, tokenHash, err := evs.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("create email verification: %w", err)
+	}
+	duration := evs.Duration
+	if duration == 0 {
+		duration = DefaultVerificationDuration
+	}
+	verification := EmailVerification{
+		UserID:    userID,
+		Email:     email,
+		Token:     token,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(duration),
+	}
+
+	// Insert the EmailVerification into the DB.
+	row = evs.DB.QueryRow(`
+		INSERT INTO email_verifications(user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4) ON CONFLICT (user_id) DO
+		UPDATE
+		SET email = $2, token_hash = $3, expires_at = $4
+		RETURNING id;`, verification.UserID, verification.Email,
+		verification.TokenHash, verification.ExpiresAt)
+	err = row.Scan(&verification.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create email verification: %w", err)
+	}
+
+	return &verification, nil
+}
+
+// Reissue replaces the token of the email verification with a new one, so
+// that a link to it can be sent when the token itself wasn't kept. It returns
+// ErrNotFound if the verification was used or has expired.
+func (evs *EmailVerificationService) Reissue(id int) (*EmailVerification, error) {
+	token, tokenHash, err := evs.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("reissue email verification: %w", err)
+	}
+	verification := EmailVerification{
+		ID:        id,
+		Token:     token,
+		TokenHash: tokenHash,
+	}
+
+	row := evs.DB.QueryRow(`
+		UPDATE email_verifications
+		SET token_hash = $2
+		WHERE id = $1 AND expires_at > now()
+		RETURNING user_id, email, expires_at;`, id, tokenHash)
+	err = row.Scan(&verification.UserID, &verification.Email, &verification.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("reissue email verification: %w", err)
+	}
+
+	return &verification, nil
+}
+
+// Consume verifies the email address the token was issued for and makes it
+// the user's email address. It returns ErrNotFound for unknown tokens,
+// ErrTokenExpired for expired ones, and ErrEmailTaken if another user has
+// started using the address in the meantime.
+func (evs *EmailVerificationService) Consume(token string) (*User, error) {
+	tokenHash := evs.TokenManager.Hash(token)
+	var verification EmailVerification
+
+	tx, err := evs.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("consume email verification: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`
+		DELETE FROM email_verifications
+		WHERE token_hash = $1
+		RETURNING id, user_id, email, expires_at;`, tokenHash)
+	err = row.Scan(&verification.ID, &verification.UserID,
+		&verification.Email, &verification.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("consume email verification: %w", err)
+	}
+
+	if time.Now().After(verification.ExpiresAt) {
+		// Commit so that the expired verification is removed.
+		err = tx.Commit()
+		if err != nil {
+			return nil, fmt.Errorf("consume email verification: %w", err)
+		}
+		return nil, ErrTokenExpired
+	}
+
+	user := User{
+		ID:            verification.UserID,
+		Email:         verification.Email,
+		EmailVerified: true,
+	}
+	_, err = tx.Exec(`
+		UPDATE users
+		SET email = $2, email_verified_at = now()
+		WHERE id = $1;`, user.ID, user.Email)
+	if err != nil {
+		var pgError *pgconn.PgError
+		if errors.As(err, &pgError) {
+			if pgError.Code == pgerrcode.UniqueViolation {
+				return nil, ErrEmailTaken
+			}
+		}
+		return nil, fmt.Errorf("consume email verification: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("consume email verification: %w", err)
+	}
+
+	return &user, nil
+}
+
+// DeleteExpired deletes every email verification that has expired.
+func (evs *EmailVerificationService) DeleteExpired() error {
+	_, err := evs.DB.Exec(`
+		DELETE FROM email_verifications
+		WHERE expires_at <= now();`)
+	if err != nil {
+		return fmt.Errorf("delete expired email verifications: %w", err)
+	}
+
+	return nil
+}
@@ -9,38 +9,67 @@ import (
 )
 
 var (
-	ErrEmailTaken       = errors.New("models: email address is already in use")
-	ErrUserDoesNotExist = errors.New("models: user with provided email address does not exist")
-	ErrNotFound         = errors.New("models: resource could not be found")
+	ErrEmailTaken            = errors.New("models: email address is already in use")
+	ErrUserDoesNotExist      = errors.New("models: user with provided email address does not exist")
+	ErrNotFound              = errors.New("models: resource could not be found")
+	ErrTokenExpired          = errors.New("models: token has expired")
+	ErrTitleRequired         = errors.New("models: gallery title is required")
+	ErrTitleTooLong          = errors.New("models: gallery title is too long")
+	ErrInvalidCode           = errors.New("models: two-factor code is invalid")
+	ErrTwoFactorEnabled      = errors.New("models: two-factor authentication is already enabled")
+	ErrNameRequired          = errors.New("models: name is required")
+	ErrNameTooLong           = errors.New("models: name is too long")
+	ErrInvalidVisibility     = errors.New("models: gallery visibility is invalid")
+	ErrPasswordRequired      = errors.New("models: password is required")
+	ErrWrongPassword         = errors.New("models: password is incorrect")
+	ErrInvalidArchive        = errors.New("models: file is not a valid zip archive")
+	ErrArchiveTooLarge       = errors.New("models: zip archive is too large")
+	ErrInvalidMetadataPolicy = errors.New("models: metadata policy is invalid")
+	ErrInvalidOrder          = errors.New("models: image order must list every image of the gallery once")
+	ErrImageTitleTooLong     = errors.New("models: image title is too long")
+	ErrCaptionTooLong        = errors.New("models: caption is too long")
+	ErrAltTextTooLong        = errors.New("models: alt text is too long")
+	ErrDescriptionTooLong    = errors.New("models: description is too long")
+	ErrInvalidTag            = errors.New("models: tag is invalid")
+	ErrTooManyTags           = errors.New("models: too many tags")
+	ErrTooManyPixels         = errors.New("models: image has too many pixels")
 )
 
 type FileError struct {
 	Issue string
+	// Err is the error that caused the issue, if any.
+	Err error
 }
 
 func (f FileError) Error() string {
 	return fmt.Sprintf("invalid file: %v", f.Issue)
 }
 
-func checkContentType(r io.ReadSeeker, allowedTypes []string) error {
+func (f FileError) Unwrap() error {
+	return f.Err
+}
+
+// checkContentType sniffs the content type of r and returns it if it is one of
+// the allowed types. r is rewound to the start before returning.
+func checkContentType(r io.ReadSeeker, allowedTypes []string) (string, error) {
 	testBytes := make([]byte, 512)
-	_, err := r.Read(testBytes)
+	n, err := r.Read(testBytes)
 	if err != nil {
-		return fmt.Errorf("checking content type: %w", err)
+		return "", fmt.Errorf("checking content type: %w", err)
 	}
 	_, err = r.Seek(0, 0)
 	if err != nil {
-		return fmt.Errorf("checking content type: %w", err)
+		return "", fmt.Errorf("checking content type: %w", err)
 	}
 
-	contentType := http.DetectContentType(testBytes)
+	contentType := http.DetectContentType(testBytes[:n])
 	for _, t := range allowedTypes {
 		if contentType == t {
-			return nil
+			return contentType, nil
 		}
 	}
 
-	return FileError{
+	return "", FileError{
 		Issue: fmt.Sprintf("invalid content type: %v", contentType),
 	}
 }
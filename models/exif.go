@@ -0,0 +1,403 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EXIF tags read by this package. See the EXIF 2.32 specification for their
+// meaning.
+const (
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExposureTime     = 0x829a
+	tagFNumber          = 0x829d
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920a
+	tagLensMake         = 0xa433
+	tagLensModel        = 0xa434
+	exifDateTimeLayout  = "2006:01:02 15:04:05"
+)
+
+// TIFF field types and their sizes in bytes.
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeSByte     = 6
+	typeUndefined = 7
+	typeSShort    = 8
+	typeSLong     = 9
+	typeSRational = 10
+	typeFloat     = 11
+	typeDouble    = 12
+	typeIFD       = 13
+)
+
+var typeSizes = map[uint16]uint32{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeSByte:     1,
+	typeUndefined: 1,
+	typeSShort:    2,
+	typeSLong:     4,
+	typeSRational: 8,
+	typeFloat:     4,
+	typeDouble:    8,
+	typeIFD:       4,
+}
+
+var errNoExif = errors.New("no exif data")
+
+// ImageMetadata holds the camera settings recorded in an image's EXIF data.
+// Values that were not recorded are left empty.
+type ImageMetadata struct {
+	// CapturedAt is the local time of the camera when the photo was taken.
+	// Cameras rarely record their time zone, so it is represented in UTC.
+	CapturedAt *time.Time
+	Camera     string
+	Lens       string
+	// ExposureTime is in seconds, formatted the way photographers write it,
+	// e.g. 1/250.
+	ExposureTime string
+	FNumber      float64
+	ISO          int
+	// FocalLength is in millimeters.
+	FocalLength float64
+}
+
+// exifEntry is a field of an image file directory.
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	// offset is where the value starts, relative to the TIFF header.
+	offset uint32
+}
+
+// exifData is the TIFF structure embedded in the APP1 segment of a JPEG.
+type exifData struct {
+	order binary.ByteOrder
+	// tiff starts at the TIFF header. All offsets are relative to it.
+	tiff []byte
+	ifd0 []exifEntry
+	exif []exifEntry
+	gps  []exifEntry
+	// gpsOffset is where the GPS IFD starts, or 0 if there is none.
+	gpsOffset uint32
+	// gpsSkipped is the number of entries of the GPS IFD that were skipped,
+	// because their type, and so the size of their value, is unknown or
+	// their value is out of bounds.
+	gpsSkipped int
+}
+
+// readMetadata extracts the ImageMetadata from a JPEG file. r is rewound to
+// the start before returning.
+func readMetadata(r io.ReadSeeker) (*ImageMetadata, error) {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Seek(0, io.SeekStart)
+
+	tiff, err := readJPEGExif(r)
+	if err != nil {
+		return nil, err
+	}
+	x, err := parseExif(tiff)
+	if err != nil {
+		return nil, err
+	}
+
+	return x.metadata(), nil
+}
+
+// readJPEGExif returns the EXIF data of a JPEG, starting at the TIFF header.
+func readJPEGExif(r io.Reader) ([]byte, error) {
+	jr, err := newJPEGReader(r)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		marker, segment, err := jr.next()
+		if err != nil {
+			return nil, err
+		}
+		if segment == nil {
+			return nil, errNoExif
+		}
+		if marker == markerAPP1 && bytes.HasPrefix(segment, exifHeader) {
+			return segment[len(exifHeader):], nil
+		}
+	}
+}
+
+// JPEG markers.
+const (
+	markerSOI   = 0xd8
+	markerEOI   = 0xd9
+	markerSOS   = 0xda
+	markerAPP1  = 0xe1
+	markerAPP13 = 0xed
+	markerAPP14 = 0xee
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// jpegReader reads the segments of a JPEG that come before the image data,
+// which is where all metadata is stored.
+type jpegReader struct {
+	br *bufio.Reader
+}
+
+func newJPEGReader(r io.Reader) (*jpegReader, error) {
+	jr := jpegReader{
+		br: bufio.NewReader(r),
+	}
+	var soi [2]byte
+	_, err := io.ReadFull(jr.br, soi[:])
+	if err != nil {
+		return nil, err
+	}
+	if soi != [2]byte{0xff, markerSOI} {
+		return nil, fmt.Errorf("not a jpeg")
+	}
+
+	return &jr, nil
+}
+
+// next returns the marker and contents of the next segment. When the image
+// data starts, the SOS or EOI marker is returned with a nil segment and the
+// rest of the file can be read from jr.br.
+func (jr *jpegReader) next() (byte, []byte, error) {
+	var marker [2]byte
+	_, err := io.ReadFull(jr.br, marker[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	if marker[0] != 0xff {
+		return 0, nil, fmt.Errorf("invalid jpeg marker")
+	}
+	// Markers may be padded with any number of 0xff bytes.
+	for marker[1] == 0xff {
+		marker[1], err = jr.br.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if marker[1] == markerSOS || marker[1] == markerEOI {
+		return marker[1], nil, nil
+	}
+
+	var length uint16
+	err = binary.Read(jr.br, binary.BigEndian, &length)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length < 2 {
+		return 0, nil, fmt.Errorf("invalid jpeg segment length")
+	}
+	segment := make([]byte, length-2)
+	_, err = io.ReadFull(jr.br, segment)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return marker[1], segment, nil
+}
+
+// parseExif parses the TIFF structure of the EXIF data.
+func parseExif(tiff []byte) (*exifData, error) {
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("exif: header too short")
+	}
+	x := exifData{
+		tiff: tiff,
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		x.order = binary.LittleEndian
+	case "MM":
+		x.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("exif: invalid byte order")
+	}
+	if x.order.Uint16(tiff[2:]) != 42 {
+		return nil, fmt.Errorf("exif: invalid tiff header")
+	}
+
+	var err error
+	x.ifd0, _, err = x.readIFD(x.order.Uint32(tiff[4:]))
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := find(x.ifd0, tagExifIFD); ok && x.long(e) != 0 {
+		x.exif, _, err = x.readIFD(x.long(e))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if e, ok := find(x.ifd0, tagGPSIFD); ok && x.long(e) != 0 {
+		x.gpsOffset = x.long(e)
+		x.gps, x.gpsSkipped, err = x.readIFD(x.gpsOffset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &x, nil
+}
+
+// readIFD reads the entries of the IFD at offset. Entries of unknown types
+// are skipped and counted, as are entries whose value is out of bounds.
+func (x *exifData) readIFD(offset uint32) ([]exifEntry, int, error) {
+	if uint64(offset)+2 > uint64(len(x.tiff)) {
+		return nil, 0, fmt.Errorf("exif: ifd out of bounds")
+	}
+	n := uint32(x.order.Uint16(x.tiff[offset:]))
+	start := offset + 2
+	if uint64(start)+uint64(n)*12 > uint64(len(x.tiff)) {
+		return nil, 0, fmt.Errorf("exif: ifd out of bounds")
+	}
+
+	var skipped int
+	entries := make([]exifEntry, 0, n)
+	for i := uint32(0); i < n; i++ {
+		b := x.tiff[start+i*12:]
+		e := exifEntry{
+			tag:   x.order.Uint16(b),
+			typ:   x.order.Uint16(b[2:]),
+			count: x.order.Uint32(b[4:]),
+		}
+		size, ok := typeSizes[e.typ]
+		if !ok {
+			skipped++
+			continue
+		}
+		total := uint64(size) * uint64(e.count)
+		if total <= 4 {
+			e.offset = start + i*12 + 8
+		} else {
+			e.offset = x.order.Uint32(b[8:])
+		}
+		if uint64(e.offset)+total > uint64(len(x.tiff)) {
+			skipped++
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, skipped, nil
+}
+
+func find(entries []exifEntry, tag uint16) (exifEntry, bool) {
+	for _, e := range entries {
+		if e.tag == tag {
+			return e, true
+		}
+	}
+	return exifEntry{}, false
+}
+
+func (x *exifData) long(e exifEntry) uint32 {
+	switch e.typ {
+	case typeShort:
+		return uint32(x.order.Uint16(x.tiff[e.offset:]))
+	case typeLong, typeSLong:
+		return x.order.Uint32(x.tiff[e.offset:])
+	}
+	return 0
+}
+
+func (x *exifData) ascii(entries []exifEntry, tag uint16) string {
+	e, ok := find(entries, tag)
+	if !ok || e.typ != typeASCII {
+		return ""
+	}
+	value := x.tiff[e.offset : e.offset+e.count]
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(string(value))
+}
+
+func (x *exifData) rational(entries []exifEntry, tag uint16) (num, den uint32, ok bool) {
+	e, ok := find(entries, tag)
+	if !ok || e.typ != typeRational || e.count < 1 {
+		return 0, 0, false
+	}
+	num = x.order.Uint32(x.tiff[e.offset:])
+	den = x.order.Uint32(x.tiff[e.offset+4:])
+	return num, den, den != 0
+}
+
+func (x *exifData) metadata() *ImageMetadata {
+	var md ImageMetadata
+
+	cameraMake := x.ascii(x.ifd0, tagMake)
+	model := x.ascii(x.ifd0, tagModel)
+	// Many cameras repeat the manufacturer in the model name.
+	if strings.HasPrefix(strings.ToLower(model), strings.ToLower(cameraMake)) {
+		cameraMake = ""
+	}
+	md.Camera = strings.TrimSpace(cameraMake + " " + model)
+
+	lensMake := x.ascii(x.exif, tagLensMake)
+	lensModel := x.ascii(x.exif, tagLensModel)
+	if strings.HasPrefix(strings.ToLower(lensModel), strings.ToLower(lensMake)) {
+		lensMake = ""
+	}
+	md.Lens = strings.TrimSpace(lensMake + " " + lensModel)
+
+	if num, den, ok := x.rational(x.exif, tagExposureTime); ok && num != 0 {
+		md.ExposureTime = formatExposureTime(num, den)
+	}
+	if num, den, ok := x.rational(x.exif, tagFNumber); ok {
+		md.FNumber = float64(num) / float64(den)
+	}
+	if num, den, ok := x.rational(x.exif, tagFocalLength); ok {
+		md.FocalLength = float64(num) / float64(den)
+	}
+	if e, ok := find(x.exif, tagISO); ok {
+		md.ISO = int(x.long(e))
+	}
+
+	capturedAt := x.ascii(x.exif, tagDateTimeOriginal)
+	if capturedAt == "" {
+		capturedAt = x.ascii(x.ifd0, tagDateTime)
+	}
+	if capturedAt != "" {
+		t, err := time.Parse(exifDateTimeLayout, capturedAt)
+		if err == nil {
+			md.CapturedAt = &t
+		}
+	}
+
+	return &md
+}
+
+// formatExposureTime formats an exposure time of num/den seconds, e.g. as
+// 1/250 or 2.5.
+func formatExposureTime(num, den uint32) string {
+	if num >= den {
+		return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+	}
+	return fmt.Sprintf("1/%d", (den+num/2)/num)
+}
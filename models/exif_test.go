@@ -0,0 +1,269 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+// tiffEntry is an IFD entry for buildTIFF. value holds the encoded value;
+// values longer than 4 bytes are stored after the IFDs.
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// buildTIFF returns EXIF data, starting at the TIFF header, with the entries
+// in IFD0 and, if they aren't nil, an Exif and a GPS IFD linked from IFD0.
+func buildTIFF(order binary.ByteOrder, ifd0, exif, gps []tiffEntry) []byte {
+	ifdSize := func(entries []tiffEntry) uint32 {
+		return 2 + 12*uint32(len(entries)) + 4
+	}
+	ifd0 = append([]tiffEntry(nil), ifd0...)
+	if exif != nil {
+		ifd0 = append(ifd0, tiffEntry{tag: tagExifIFD, typ: typeLong, count: 1})
+	}
+	if gps != nil {
+		ifd0 = append(ifd0, tiffEntry{tag: tagGPSIFD, typ: typeLong, count: 1})
+	}
+	exifOffset := 8 + ifdSize(ifd0)
+	gpsOffset := exifOffset
+	if exif != nil {
+		gpsOffset += ifdSize(exif)
+	}
+	dataOffset := gpsOffset
+	if gps != nil {
+		dataOffset += ifdSize(gps)
+	}
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case tagExifIFD:
+			ifd0[i].value = u32(order, exifOffset)
+		case tagGPSIFD:
+			ifd0[i].value = u32(order, gpsOffset)
+		}
+	}
+
+	var buf, data []byte
+	if order == binary.LittleEndian {
+		buf = append(buf, "II"...)
+	} else {
+		buf = append(buf, "MM"...)
+	}
+	buf = append(buf, u16(order, 42)...)
+	buf = append(buf, u32(order, 8)...)
+	for _, entries := range [][]tiffEntry{ifd0, exif, gps} {
+		if entries == nil {
+			continue
+		}
+		buf = append(buf, u16(order, uint16(len(entries)))...)
+		for _, e := range entries {
+			buf = append(buf, u16(order, e.tag)...)
+			buf = append(buf, u16(order, e.typ)...)
+			buf = append(buf, u32(order, e.count)...)
+			if len(e.value) <= 4 {
+				value := make([]byte, 4)
+				copy(value, e.value)
+				buf = append(buf, value...)
+			} else {
+				buf = append(buf, u32(order, dataOffset+uint32(len(data)))...)
+				data = append(data, e.value...)
+			}
+		}
+		buf = append(buf, u32(order, 0)...)
+	}
+
+	return append(buf, data...)
+}
+
+func u16(order binary.ByteOrder, v uint16) []byte {
+	b := make([]byte, 2)
+	order.PutUint16(b, v)
+	return b
+}
+
+func u32(order binary.ByteOrder, v uint32) []byte {
+	b := make([]byte, 4)
+	order.PutUint32(b, v)
+	return b
+}
+
+func asciiEntry(tag uint16, s string) tiffEntry {
+	return tiffEntry{tag: tag, typ: typeASCII, count: uint32(len(s) + 1), value: []byte(s + "\x00")}
+}
+
+func rationalEntry(order binary.ByteOrder, tag uint16, num, den uint32) tiffEntry {
+	return tiffEntry{tag: tag, typ: typeRational, count: 1, value: append(u32(order, num), u32(order, den)...)}
+}
+
+func shortEntry(order binary.ByteOrder, tag, v uint16) tiffEntry {
+	return tiffEntry{tag: tag, typ: typeShort, count: 1, value: u16(order, v)}
+}
+
+// testJPEG returns a small JPEG with the given segments after the start of
+// image marker. Each segment starts with its marker.
+func testJPEG(t *testing.T, segments ...[]byte) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := append([]byte(nil), encoded.Bytes()[:2]...)
+	for _, segment := range segments {
+		b = append(b, segment...)
+	}
+	return append(b, encoded.Bytes()[2:]...)
+}
+
+// jpegSegment encodes a JPEG segment with the marker and contents.
+func jpegSegment(marker byte, contents []byte) []byte {
+	b := []byte{0xff, marker}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(contents)+2))
+	return append(b, contents...)
+}
+
+func exifSegment(tiff []byte) []byte {
+	return jpegSegment(markerAPP1, append(append([]byte(nil), exifHeader...), tiff...))
+}
+
+func TestReadMetadata(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			tiff := buildTIFF(order,
+				[]tiffEntry{
+					asciiEntry(tagMake, "Canon"),
+					asciiEntry(tagModel, "Canon EOS R5"),
+					asciiEntry(tagDateTime, "2023:05:02 08:00:00"),
+				},
+				[]tiffEntry{
+					rationalEntry(order, tagExposureTime, 1, 250),
+					rationalEntry(order, tagFNumber, 28, 10),
+					shortEntry(order, tagISO, 400),
+					asciiEntry(tagDateTimeOriginal, "2023:05:01 10:20:30"),
+					rationalEntry(order, tagFocalLength, 50, 1),
+					asciiEntry(tagLensModel, "RF50mm F1.8 STM"),
+				},
+				nil)
+
+			md, err := readMetadata(bytes.NewReader(testJPEG(t, exifSegment(tiff))))
+			if err != nil {
+				t.Fatalf("readMetadata() err = %v", err)
+			}
+			want := time.Date(2023, 5, 1, 10, 20, 30, 0, time.UTC)
+			if md.CapturedAt == nil || !md.CapturedAt.Equal(want) {
+				t.Errorf("CapturedAt = %v, want %v", md.CapturedAt, want)
+			}
+			if md.Camera != "Canon EOS R5" {
+				t.Errorf("Camera = %q, want %q", md.Camera, "Canon EOS R5")
+			}
+			if md.Lens != "RF50mm F1.8 STM" {
+				t.Errorf("Lens = %q, want %q", md.Lens, "RF50mm F1.8 STM")
+			}
+			if md.ExposureTime != "1/250" {
+				t.Errorf("ExposureTime = %q, want %q", md.ExposureTime, "1/250")
+			}
+			if md.FNumber != 2.8 {
+				t.Errorf("FNumber = %v, want 2.8", md.FNumber)
+			}
+			if md.ISO != 400 {
+				t.Errorf("ISO = %v, want 400", md.ISO)
+			}
+			if md.FocalLength != 50 {
+				t.Errorf("FocalLength = %v, want 50", md.FocalLength)
+			}
+		})
+	}
+}
+
+func TestReadMetadataErrors(t *testing.T) {
+	tiff := buildTIFF(binary.LittleEndian, []tiffEntry{asciiEntry(tagModel, "EOS R5")}, nil, nil)
+	tests := map[string][]byte{
+		"not a jpeg":        []byte("GIF89a"),
+		"no exif":           testJPEG(t),
+		"truncated segment": testJPEG(t, exifSegment(tiff))[:20],
+	}
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readMetadata(bytes.NewReader(b))
+			if err == nil {
+				t.Fatal("readMetadata() err = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestParseExifErrors(t *testing.T) {
+	le := binary.LittleEndian
+	tests := map[string][]byte{
+		"short header":       []byte("II*\x00"),
+		"invalid byte order": []byte("XX*\x00\x08\x00\x00\x00"),
+		"invalid magic":      []byte("II+\x00\x08\x00\x00\x00"),
+		"ifd0 out of bounds": []byte("II*\x00\x64\x00\x00\x00"),
+		"entries out of bounds": append([]byte("II*\x00\x08\x00\x00\x00"),
+			u16(le, 5)...),
+		"exif ifd out of bounds": buildTIFF(le, []tiffEntry{
+			{tag: tagExifIFD, typ: typeLong, count: 1, value: u32(le, 1000)},
+		}, nil, nil),
+		"gps ifd out of bounds": buildTIFF(binary.BigEndian, []tiffEntry{
+			{tag: tagGPSIFD, typ: typeLong, count: 1, value: u32(binary.BigEndian, 1000)},
+		}, nil, nil),
+	}
+	for name, tiff := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseExif(tiff)
+			if err == nil {
+				t.Fatal("parseExif() err = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestParseExifSkipsValuesOutOfBounds(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			tiff := buildTIFF(order, []tiffEntry{
+				shortEntry(order, tagOrientation, orientationRotate90),
+				asciiEntry(tagModel, "A model name that is stored out of line"),
+			}, nil, nil)
+			// Cut the value of the model off.
+			tiff = tiff[:len(tiff)-10]
+
+			x, err := parseExif(tiff)
+			if err != nil {
+				t.Fatalf("parseExif() err = %v", err)
+			}
+			if _, ok := find(x.ifd0, tagModel); ok {
+				t.Error("entry with a value out of bounds was read")
+			}
+			e, ok := find(x.ifd0, tagOrientation)
+			if !ok || x.long(e) != orientationRotate90 {
+				t.Errorf("orientation = %v, %v, want %v", x.long(e), ok, orientationRotate90)
+			}
+		})
+	}
+}
+
+func TestFormatExposureTime(t *testing.T) {
+	tests := []struct {
+		num, den uint32
+		want     string
+	}{
+		{1, 250, "1/250"},
+		{10, 2500, "1/250"},
+		{1, 3, "1/3"},
+		{2, 1, "2"},
+		{10, 4, "2.5"},
+	}
+	for _, tt := range tests {
+		got := formatExposureTime(tt.num, tt.den)
+		if got != tt.want {
+			t.Errorf("formatExposureTime(%d, %d) = %q, want %q", tt.num, tt.den, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// MaxOriginalFilenameLength is the maximum number of characters kept of
+	// the name an image was uploaded with.
+	MaxOriginalFilenameLength = 255
+	// maxFilenameStemLength is the maximum number of characters in the name
+	// an image is stored under, not counting the extension.
+	maxFilenameStemLength = 100
+)
+
+// baseFilename returns the last element of a client-supplied file name. Some
+// browsers send the full path of the file, with backslashes on Windows.
+func baseFilename(name string) string {
+	name = strings.ToValidUTF8(name, "")
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSpace(name)
+}
+
+// originalFilename returns the name an image was uploaded as, the way it is
+// shown to users.
+func originalFilename(name string) string {
+	name = baseFilename(name)
+	if utf8.RuneCountInString(name) > MaxOriginalFilenameLength {
+		name = string([]rune(name)[:MaxOriginalFilenameLength])
+	}
+	return name
+}
+
+// sanitizeFilename turns a client-supplied file name into the name an image
+// is stored under. The name is decomposed with NFKD and stripped of accents,
+// so "Café.JPG" becomes "Cafe.jpg". Letters and digits of any script and
+// underscores are kept, while runs of spaces, dots, hyphens and any other
+// characters become a single hyphen. The extension is lowercased.
+func sanitizeFilename(name string) string {
+	name = baseFilename(name)
+	ext := path.Ext(name)
+	stem := sanitizeFilenamePart(strings.TrimSuffix(name, ext), maxFilenameStemLength)
+	if stem == "" {
+		stem = "image"
+	}
+	ext = sanitizeFilenamePart(strings.TrimPrefix(ext, "."), maxFilenameStemLength)
+	if ext == "" {
+		return stem
+	}
+	return stem + "." + strings.ToLower(ext)
+}
+
+func sanitizeFilenamePart(s string, maxLength int) string {
+	var b strings.Builder
+	var n int
+	separate := false
+	for _, r := range norm.NFKD.String(s) {
+		if n >= maxLength {
+			break
+		}
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Accents are split from their letters by NFKD.
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if separate && n > 0 {
+				b.WriteByte('-')
+				n++
+			}
+			separate = false
+			b.WriteRune(r)
+			n++
+		default:
+			separate = true
+		}
+	}
+	return b.String()
+}
+
+// lockGalleryFilenames is the first key of the advisory locks that serialize
+// the choice of filenames in a gallery. The second key is the gallery ID.
+const lockGalleryFilenames = 1
+
+// contentHash returns the hex-encoded SHA-256 hash of the contents of r. r is
+// rewound to the start before returning.
+func contentHash(r io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return "", fmt.Errorf("hashing contents: %w", err)
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", fmt.Errorf("hashing contents: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// uniqueFilename returns the name to store an upload with the given content
+// hash under, and whether it replaces the image already stored under that
+// name. It returns filename, unless another image of the gallery, including
+// those in the trash, is stored under it with different contents. Then the
+// start of the hash is added to the name, or the whole hash if even that name
+// is taken. Callers must hold the lock on the gallery's filenames.
+func uniqueFilename(tx *sql.Tx, galleryID int, filename, hash string) (string, bool, error) {
+	ext := path.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext)
+	candidates := []string{
+		filename,
+		stem + "-" + hash[:8] + ext,
+		stem + "-" + hash + ext,
+	}
+	for _, name := range candidates {
+		var existing sql.NullString
+		row := tx.QueryRow(`
+			SELECT content_hash
+			FROM images
+			WHERE gallery_id = $1 AND filename = $2;`, galleryID, name)
+		err := row.Scan(&existing)
+		if errors.Is(err, sql.ErrNoRows) {
+			return name, false, nil
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("unique filename: %w", err)
+		}
+		if existing.String == hash {
+			return name, true, nil
+		}
+	}
+
+	// Not reached: a name with the whole hash is only taken by the same
+	// contents.
+	return "", false, fmt.Errorf("unique filename: no name available for %v", filename)
+}
@@ -1,53 +1,141 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/fs"
-	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-)
+	"time"
+	"unicode/utf8"
 
-type Image struct {
-	GalleryID int
-	Path      string
-	Filename  string
-}
+	"github.com/alexproskurov/snapfolio/rand"
+	"golang.org/x/crypto/bcrypt"
+)
 
 type Gallery struct {
 	ID     int
 	UserID int
 	Title  string
+	// Description is written in Markdown.
+	Description string
+	Visibility  string
+	// PasswordHash is only set for password-protected galleries.
+	PasswordHash string
+	// AccessKey is only set for unlisted galleries. Anyone who knows it can
+	// view the gallery.
+	AccessKey string
+	// MetadataPolicy is applied to images uploaded to the gallery.
+	MetadataPolicy string
+	// CoverImageID is the image the owner chose to represent the gallery, or
+	// the first image if they haven't chosen one. It is 0 if the gallery
+	// has no images.
+	CoverImageID int
+	// Tags are normalized to lower case and sorted.
+	Tags []string
+}
+
+// Gallery visibilities. Galleries are private until their owner decides to
+// share them.
+const (
+	// VisibilityPrivate galleries can only be viewed by their owner.
+	VisibilityPrivate = "private"
+	// VisibilityUnlisted galleries can be viewed by anyone with a link that
+	// includes the gallery's AccessKey.
+	VisibilityUnlisted = "unlisted"
+	// VisibilityPublic galleries can be viewed by anyone.
+	VisibilityPublic = "public"
+	// VisibilityPassword galleries can be viewed by anyone who knows the
+	// gallery's password.
+	VisibilityPassword = "password"
+)
+
+// Visibilities lists every valid gallery visibility.
+var Visibilities = []string{
+	VisibilityPrivate,
+	VisibilityUnlisted,
+	VisibilityPublic,
+	VisibilityPassword,
+}
+
+const (
+	// MaxTitleLength is the maximum number of characters allowed in a
+	// gallery title.
+	MaxTitleLength = 255
+	// MaxDescriptionLength is the maximum number of characters allowed in a
+	// gallery description.
+	MaxDescriptionLength = 10000
+	// DefaultGalleriesPerPage is the number of galleries returned by
+	// GetByUserID when ListOptions.PerPage is not set.
+	DefaultGalleriesPerPage = 24
+	// galleryAccessKeyBytes is the number of random bytes in the access key
+	// of unlisted galleries.
+	galleryAccessKeyBytes = 16
+)
+
+// ListOptions is used to paginate queries that may return many rows.
+// Page is 1-based; values less than 1 are treated as the first page.
+type ListOptions struct {
+	Page    int
+	PerPage int
+	// Tag, if set, limits GalleryService.GetByUserID to galleries with the
+	// tag.
+	Tag string
+}
+
+func (o ListOptions) limit(defaultPerPage int) int {
+	if o.PerPage <= 0 {
+		return defaultPerPage
+	}
+	return o.PerPage
+}
+
+func (o ListOptions) offset(defaultPerPage int) int {
+	if o.Page <= 1 {
+		return 0
+	}
+	return (o.Page - 1) * o.limit(defaultPerPage)
 }
 
 type GalleryService struct {
 	DB *sql.DB
 
-	// ImagesDir is used to tell the GalleryService where to store and locate
-	// images. If not set, the GalleryService will default to using the "images"
-	// directory.
-	ImagesDir string
+	// Storage is where the gallery's image files are stored. If not set, the
+	// GalleryService will default to using DefaultStorage.
+	Storage Storage
+	// Jobs is used to remove the files of deleted galleries in the
+	// background. If not set, files are removed while the gallery is deleted.
+	Jobs *JobService
+}
+
+type galleryJob struct {
+	GalleryID int
 }
 
 func (s *GalleryService) Create(userID int, title string) (*Gallery, error) {
 	if userID < 0 {
 		return nil, fmt.Errorf("create gallery: userID must be a positive number. userId = %d", userID)
 	}
-	if title == "" {
-		return nil, fmt.Errorf("create gallery: empty title")
+	title = strings.TrimSpace(title)
+	err := validateTitle(title)
+	if err != nil {
+		return nil, fmt.Errorf("create gallery: %w", err)
 	}
 	gallery := Gallery{
-		UserID: userID,
-		Title:  title,
+		UserID:         userID,
+		Title:          title,
+		Visibility:     VisibilityPrivate,
+		MetadataPolicy: MetadataStrip,
+		Tags:           []string{},
 	}
 
 	row := s.DB.QueryRow(`
 		INSERT INTO galleries (user_id, title)
 		VALUES ($1, $2) RETURNING id;`, gallery.UserID, gallery.Title)
-	err := row.Scan(&gallery.ID)
+	err = row.Scan(&gallery.ID)
 	if err != nil {
 		return nil, fmt.Errorf("create gallery: %w", err)
 	}
@@ -63,191 +151,345 @@ func (s *GalleryService) GetByID(id int) (*Gallery, error) {
 		ID: id,
 	}
 
+	var passwordHash, accessKey sql.NullString
 	row := s.DB.QueryRow(`
-		SELECT user_id, title 
+		SELECT user_id, title, description, visibility, password_hash, access_key,
+			metadata_policy, COALESCE((
+				SELECT id FROM images
+				WHERE images.gallery_id = galleries.id AND images.deleted_at IS NULL
+				ORDER BY images.id = galleries.cover_image_id DESC, position, id
+				LIMIT 1), 0),
+			COALESCE((SELECT string_agg(tag, ',' ORDER BY tag)
+				FROM gallery_tags
+				WHERE gallery_tags.gallery_id = galleries.id), '')
 		FROM galleries
-		WHERE id = $1;`, gallery.ID)
-	err := row.Scan(&gallery.UserID, &gallery.Title)
+		WHERE id = $1 AND deleted_at IS NULL;`, gallery.ID)
+	var tags string
+	err := row.Scan(&gallery.UserID, &gallery.Title, &gallery.Description,
+		&gallery.Visibility, &passwordHash, &accessKey, &gallery.MetadataPolicy,
+		&gallery.CoverImageID, &tags)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
 		}
 		return nil, fmt.Errorf("query gallery by id: %w", err)
 	}
+	gallery.PasswordHash = passwordHash.String
+	gallery.AccessKey = accessKey.String
+	gallery.Tags = splitTags(tags)
 
 	return &gallery, nil
 }
 
-func (s *GalleryService) GetByUserID(userID int) ([]Gallery, error) {
+// GetByUserID returns a page of the user's galleries, newest first. If
+// opts.Tag is set, only galleries with the tag are returned.
+func (s *GalleryService) GetByUserID(userID int, opts ListOptions) ([]Gallery, error) {
 	if userID < 0 {
 		return nil, fmt.Errorf("query galleries by user id: user id must be a positive number. user id = %d", userID)
 	}
 
 	rows, err := s.DB.Query(`
-		SELECT id, title 
+		SELECT id, title, description, visibility, metadata_policy,
+			COALESCE((
+				SELECT id FROM images
+				WHERE images.gallery_id = galleries.id AND images.deleted_at IS NULL
+				ORDER BY images.id = galleries.cover_image_id DESC, position, id
+				LIMIT 1), 0),
+			COALESCE((SELECT string_agg(tag, ',' ORDER BY tag)
+				FROM gallery_tags
+				WHERE gallery_tags.gallery_id = galleries.id), '')
 		FROM galleries
-		WHERE user_id = $1;`, userID)
+		WHERE user_id = $1 AND deleted_at IS NULL AND ($4 = '' OR EXISTS (
+			SELECT 1 FROM gallery_tags
+			WHERE gallery_tags.gallery_id = galleries.id AND tag = $4))
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3;`, userID,
+		opts.limit(DefaultGalleriesPerPage), opts.offset(DefaultGalleriesPerPage),
+		normalizeTag(opts.Tag))
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNotFound
-		}
 		return nil, fmt.Errorf("query galleries by user id: %w", err)
 	}
+	defer rows.Close()
 
 	galleries := make([]Gallery, 0)
 	for rows.Next() {
 		gallery := Gallery{
 			UserID: userID,
 		}
-		err = rows.Scan(&gallery.ID, &gallery.Title)
+		var tags string
+		err = rows.Scan(&gallery.ID, &gallery.Title, &gallery.Description,
+			&gallery.Visibility, &gallery.MetadataPolicy, &gallery.CoverImageID, &tags)
 		if err != nil {
 			return nil, fmt.Errorf("query galleries by user id: %w", err)
 		}
+		gallery.Tags = splitTags(tags)
 
 		galleries = append(galleries, gallery)
 	}
-	if rows.Err() != nil {
+	err = rows.Err()
+	if err != nil {
 		return nil, fmt.Errorf("query galleries by user id: %w", err)
 	}
 
 	return galleries, nil
 }
 
+// CountByUserID returns the total number of galleries owned by the user. If
+// tag is not empty, only galleries with the tag are counted.
+func (s *GalleryService) CountByUserID(userID int, tag string) (int, error) {
+	var count int
+	row := s.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM galleries
+		WHERE user_id = $1 AND deleted_at IS NULL AND ($2 = '' OR EXISTS (
+			SELECT 1 FROM gallery_tags
+			WHERE gallery_tags.gallery_id = galleries.id AND tag = $2));`, userID, normalizeTag(tag))
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count galleries by user id: %w", err)
+	}
+
+	return count, nil
+}
+
+// Update saves the title, description and tags of the gallery.
 func (s *GalleryService) Update(gallery *Gallery) error {
-	if gallery.Title == "" {
-		return fmt.Errorf("update gallery: empty title")
+	gallery.Title = strings.TrimSpace(gallery.Title)
+	err := validateTitle(gallery.Title)
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
+	}
+	gallery.Description = strings.TrimSpace(gallery.Description)
+	if utf8.RuneCountInString(gallery.Description) > MaxDescriptionLength {
+		return fmt.Errorf("update gallery: %w", ErrDescriptionTooLong)
+	}
+	tags, err := normalizeTags(gallery.Tags)
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
 	}
 
-	_, err := s.DB.Exec(`
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
 		UPDATE galleries
-		SET title = $2
-		WHERE id = $1;`, gallery.ID, gallery.Title)
+		SET title = $2, description = $3
+		WHERE id = $1;`, gallery.ID, gallery.Title, gallery.Description)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return ErrNotFound
 		}
 		return fmt.Errorf("update gallery: %w", err)
 	}
+	err = saveTags(tx, "gallery_tags", "gallery_id", gallery.ID, tags)
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
+	}
+	sort.Strings(tags)
+	gallery.Tags = tags
 
 	return nil
 }
 
-func (s *GalleryService) Delete(id int) error {
-	if id < 0 {
-		return fmt.Errorf("delete gallery: id must be a positive number. id = %d", id)
+// SetVisibility changes who can view the gallery. A password is required to
+// make a gallery password-protected, unless it is password-protected already,
+// in which case an empty password keeps the current one. Making a gallery
+// unlisted generates a new AccessKey, so links shared earlier stop working.
+func (s *GalleryService) SetVisibility(gallery *Gallery, visibility, password string) error {
+	var passwordHash, accessKey sql.NullString
+	switch visibility {
+	case VisibilityPrivate, VisibilityPublic:
+	case VisibilityUnlisted:
+		if gallery.Visibility == VisibilityUnlisted && gallery.AccessKey != "" {
+			accessKey = sql.NullString{String: gallery.AccessKey, Valid: true}
+			break
+		}
+		key, err := rand.String(galleryAccessKeyBytes)
+		if err != nil {
+			return fmt.Errorf("set gallery visibility: %w", err)
+		}
+		accessKey = sql.NullString{String: key, Valid: true}
+	case VisibilityPassword:
+		if password == "" {
+			if gallery.Visibility != VisibilityPassword || gallery.PasswordHash == "" {
+				return ErrPasswordRequired
+			}
+			passwordHash = sql.NullString{String: gallery.PasswordHash, Valid: true}
+			break
+		}
+		hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("set gallery visibility: %w", err)
+		}
+		passwordHash = sql.NullString{String: string(hashedBytes), Valid: true}
+	default:
+		return ErrInvalidVisibility
 	}
 
 	_, err := s.DB.Exec(`
-		DELETE FROM galleries 
-		WHERE id = $1;`, id)
-	if err != nil {
-		return fmt.Errorf("delete gallery: %w", err)
-	}
-	
-	err = os.RemoveAll(s.galleryDir(id))
+		UPDATE galleries
+		SET visibility = $2, password_hash = $3, access_key = $4
+		WHERE id = $1;`, gallery.ID, visibility, passwordHash, accessKey)
 	if err != nil {
-		return fmt.Errorf("delete gallery images: %w", err)
+		return fmt.Errorf("set gallery visibility: %w", err)
 	}
+	gallery.Visibility = visibility
+	gallery.PasswordHash = passwordHash.String
+	gallery.AccessKey = accessKey.String
 
 	return nil
 }
 
-func (s *GalleryService) Images(galleryID int) ([]Image, error) {
-	globPattern := filepath.Join(s.galleryDir(galleryID), "*")
-	allFiles, err := filepath.Glob(globPattern)
+// SetCover makes the image the cover of the gallery. ErrNotFound is returned
+// if the image is not in the gallery.
+func (s *GalleryService) SetCover(gallery *Gallery, imageID int) error {
+	res, err := s.DB.Exec(`
+		UPDATE galleries
+		SET cover_image_id = $2
+		WHERE id = $1 AND EXISTS (
+			SELECT 1 FROM images
+			WHERE id = $2 AND gallery_id = $1 AND deleted_at IS NULL);`, gallery.ID, imageID)
 	if err != nil {
-		return nil, fmt.Errorf("retrieving gallery images: %w", err)
+		return fmt.Errorf("set gallery cover: %w", err)
 	}
-
-	var images []Image
-	for _, file := range allFiles {
-		if hasExtension(file, s.extensions()) {
-			images = append(images, Image{
-				GalleryID: galleryID,
-				Path:      file,
-				Filename:  filepath.Base(file),
-			})
-		}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("set gallery cover: %w", err)
 	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	gallery.CoverImageID = imageID
 
-	return images, nil
+	return nil
 }
 
-func (s *GalleryService) Image(galleryID int, filename string) (Image, error) {
-	imagePath := filepath.Join(s.galleryDir(galleryID), filename)
-	_, err := os.Stat(imagePath)
+// CheckPassword returns ErrWrongPassword unless the gallery is
+// password-protected with the given password.
+func (s *GalleryService) CheckPassword(gallery *Gallery, password string) error {
+	if gallery.Visibility != VisibilityPassword || gallery.PasswordHash == "" {
+		return ErrWrongPassword
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(gallery.PasswordHash), []byte(password))
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			return Image{}, ErrNotFound
-		}
-		return Image{}, fmt.Errorf("quering for image: %w", err)
+		return ErrWrongPassword
 	}
 
-	return Image{
-		GalleryID: galleryID,
-		Path:      imagePath,
-		Filename:  filename,
-	}, nil
+	return nil
 }
 
-func (s *GalleryService) CreateImage(galleryID int, filename string, contents io.ReadSeeker) error {
-	err := checkContentType(contents, s.imageContentTypes())
-	if err != nil {
-		return fmt.Errorf("creating image %v: %w", filename, err)
+// Delete moves the gallery to the trash, along with its images. Its files
+// are kept until the TrashService purges it.
+func (s *GalleryService) Delete(id int) error {
+	if id < 0 {
+		return fmt.Errorf("delete gallery: id must be a positive number. id = %d", id)
 	}
-	err = checkExtension(filename, s.extensions())
+
+	_, err := s.DB.Exec(`
+		UPDATE galleries
+		SET deleted_at = now()
+		WHERE id = $1 AND deleted_at IS NULL;`, id)
 	if err != nil {
-		return fmt.Errorf("creating image %v: %w", filename, err)
+		return fmt.Errorf("delete gallery: %w", err)
 	}
 
-	galleryDir := s.galleryDir(galleryID)
-	err = os.MkdirAll(galleryDir, 0755)
+	return nil
+}
+
+// purge removes the gallery for good, if it is still in the trash and was
+// moved there before the given time. Its files are removed by a job queued in
+// the same transaction, or right away if the GalleryService has no Jobs.
+func (s *GalleryService) purge(id int, deletedBefore time.Time) error {
+	tx, err := s.DB.Begin()
 	if err != nil {
-		return fmt.Errorf("creating gallery-%d images directory: %w", galleryID, err)
+		return fmt.Errorf("purge gallery: %w", err)
 	}
+	defer tx.Rollback()
 
-	imagePath := filepath.Join(galleryDir, filename)
-	dst, err := os.Create(imagePath)
+	res, err := tx.Exec(`
+		DELETE FROM galleries
+		WHERE id = $1 AND deleted_at < $2;`, id, deletedBefore)
 	if err != nil {
-		return fmt.Errorf("creating image file: %w", err)
+		return fmt.Errorf("purge gallery: %w", err)
 	}
-	defer dst.Close()
-
-	_, err = io.Copy(dst, contents)
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("purge gallery: %w", err)
+	}
+	if n == 0 {
+		// The gallery was restored in the meantime.
+		return nil
+	}
+	if s.Jobs != nil {
+		err = s.Jobs.EnqueueTx(tx, JobDeleteGalleryFiles, galleryJob{GalleryID: id})
+		if err != nil {
+			return fmt.Errorf("purge gallery: %w", err)
+		}
+	}
+	err = tx.Commit()
 	if err != nil {
-		return fmt.Errorf("copying contents to image: %w", err)
+		return fmt.Errorf("purge gallery: %w", err)
 	}
+
+	if s.Jobs == nil {
+		err = s.deleteFiles(id)
+		if err != nil {
+			return fmt.Errorf("purge gallery images: %w", err)
+		}
+	}
+
 	return nil
 }
 
-func (s *GalleryService) DeleteImage(galleryID int, filename string) error {
-	image, err := s.Image(galleryID, filename)
+// HandleDeleteGalleryFiles is the JobHandler for JobDeleteGalleryFiles jobs.
+func (s *GalleryService) HandleDeleteGalleryFiles(ctx context.Context, job *Job) error {
+	var payload galleryJob
+	err := json.Unmarshal(job.Payload, &payload)
 	if err != nil {
-		return fmt.Errorf("deleting image: %w", err)
+		return fmt.Errorf("delete gallery files job: %w", err)
 	}
-	err = os.Remove(image.Path)
+
+	return s.deleteFiles(payload.GalleryID)
+}
+
+// deleteFiles removes every stored file that belongs to the gallery.
+func (s *GalleryService) deleteFiles(id int) error {
+	objects, err := s.storage().List(galleryPrefix(id))
 	if err != nil {
-		return fmt.Errorf("deleting image: %w", err)
+		return err
+	}
+	for _, obj := range objects {
+		err = s.storage().Delete(obj.Key)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (s *GalleryService) extensions() []string {
-	return []string{".png", ".jpg", ".jpeg", ".gif"}
-}
-
-func (s *GalleryService) imageContentTypes() []string {
-	return []string{"image/png", "image/jpeg", "image/gif"}
+func (s *GalleryService) storage() Storage {
+	if s.Storage == nil {
+		return DefaultStorage
+	}
+	return s.Storage
 }
 
-func (s *GalleryService) galleryDir(id int) string {
-	imagesDir := s.ImagesDir
-	if imagesDir == "" {
-		imagesDir = "images"
+func validateTitle(title string) error {
+	if title == "" {
+		return ErrTitleRequired
 	}
-
-	return filepath.Join(imagesDir, fmt.Sprintf("gallery-%d", id))
+	if utf8.RuneCountInString(title) > MaxTitleLength {
+		return ErrTitleTooLong
+	}
+	return nil
 }
 
 func hasExtension(file string, extensions []string) bool {
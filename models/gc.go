@@ -0,0 +1,119 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// orphanGracePeriod is how old an object must be before FindOrphans reports
+// it, so files of uploads that are still in progress aren't reported.
+const orphanGracePeriod = time.Hour
+
+var galleryPrefixRe = regexp.MustCompile(`^gallery-(\d+)/`)
+
+// OrphanReport lists the stored objects that no database row refers to.
+type OrphanReport struct {
+	// Galleries are the gallery directories of galleries that no longer
+	// exist.
+	Galleries []OrphanedGallery
+	// Files are the objects outside of those directories that belong to no
+	// image, including temporary files left behind by interrupted writes.
+	Files []ObjectInfo
+}
+
+// OrphanedGallery is a gallery directory whose gallery no longer exists.
+type OrphanedGallery struct {
+	Prefix  string
+	Objects int
+	Size    int64
+}
+
+// FindOrphans reports the objects in the storage that no gallery or image
+// refers to, such as the files of uploads that failed halfway. Images and
+// galleries in the trash still refer to their files. Nothing is deleted.
+func (s *ImageService) FindOrphans() (*OrphanReport, error) {
+	galleries := make(map[int]bool)
+	rows, err := s.DB.Query(`
+		SELECT id
+		FROM galleries;`)
+	if err != nil {
+		return nil, fmt.Errorf("find orphans: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("find orphans: %w", err)
+		}
+		galleries[id] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("find orphans: %w", err)
+	}
+
+	referenced := make(map[string]bool)
+	rows, err = s.DB.Query(`
+		SELECT gallery_id, filename, content_type, original_kept
+		FROM images;`)
+	if err != nil {
+		return nil, fmt.Errorf("find orphans: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var image Image
+		var originalKept bool
+		err = rows.Scan(&image.GalleryID, &image.Filename, &image.ContentType, &originalKept)
+		if err != nil {
+			return nil, fmt.Errorf("find orphans: %w", err)
+		}
+		referenced[imageKey(image.GalleryID, image.Filename)] = true
+		if originalKept {
+			referenced[originalKey(image.GalleryID, image.Filename)] = true
+		}
+		for _, key := range s.variantKeys(&image) {
+			referenced[key] = true
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("find orphans: %w", err)
+	}
+
+	objects, err := s.storage().List("")
+	if err != nil {
+		return nil, fmt.Errorf("find orphans: %w", err)
+	}
+
+	var report OrphanReport
+	orphanedGalleries := make(map[string]int)
+	cutoff := time.Now().Add(-orphanGracePeriod)
+	for _, obj := range objects {
+		if obj.ModTime.After(cutoff) {
+			continue
+		}
+		if m := galleryPrefixRe.FindStringSubmatch(obj.Key); m != nil {
+			id, err := strconv.Atoi(m[1])
+			if err == nil && !galleries[id] {
+				i, ok := orphanedGalleries[m[0]]
+				if !ok {
+					i = len(report.Galleries)
+					orphanedGalleries[m[0]] = i
+					report.Galleries = append(report.Galleries, OrphanedGallery{Prefix: m[0]})
+				}
+				report.Galleries[i].Objects++
+				report.Galleries[i].Size += obj.Size
+				continue
+			}
+		}
+		if referenced[obj.Key] {
+			continue
+		}
+		report.Files = append(report.Files, obj)
+	}
+
+	return &report, nil
+}
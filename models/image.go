@@ -0,0 +1,728 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type Image struct {
+	ID        int
+	GalleryID int
+	// UserID is the ID of the user who uploaded the image.
+	UserID int
+	// Filename is the sanitized name the image is stored under, unique in
+	// the gallery. OriginalFilename is the name it was uploaded with, which
+	// is shown to users.
+	Filename         string
+	OriginalFilename string
+	ContentType      string
+	Size             int64
+	CreatedAt        time.Time
+	// Key is the key the image is stored under in the Storage.
+	Key string
+	// Metadata is read from the EXIF data of JPEG images.
+	Metadata ImageMetadata
+	// OriginalKey is the key of the file as it was uploaded, if the
+	// gallery's metadata policy kept it. Only the owner may see it.
+	OriginalKey string
+	// Position is the place of the image in the order chosen by the owner.
+	Position int
+	// Title, Caption and AltText are written by the owner. AltText describes
+	// the image to visitors who can't see it.
+	Title   string
+	Caption string
+	AltText string
+	// Tags are normalized to lower case and sorted.
+	Tags []string
+}
+
+const (
+	// MaxCaptionLength is the maximum number of characters allowed in an
+	// image caption. Image titles are limited to MaxTitleLength.
+	MaxCaptionLength = 2000
+	// MaxAltTextLength is the maximum number of characters allowed in the
+	// alt text of an image.
+	MaxAltTextLength = 1000
+)
+
+// ImageOrder is the order in which the images of a gallery are listed.
+type ImageOrder string
+
+const (
+	// OrderPosition lists the images in the order chosen by the owner.
+	OrderPosition ImageOrder = "position"
+	// OrderUploaded lists the oldest upload first.
+	OrderUploaded ImageOrder = "uploaded"
+	// OrderCaptured lists the earliest photo first. Images without a capture
+	// time are listed last, in upload order.
+	OrderCaptured ImageOrder = "captured"
+)
+
+type ImageService struct {
+	DB *sql.DB
+
+	// Storage is where image files are stored. If not set, the ImageService
+	// will default to using DefaultStorage.
+	Storage Storage
+	// Jobs is used to process new images in the background. If not set,
+	// images are processed while they are created.
+	Jobs *JobService
+}
+
+type imageJob struct {
+	ImageID int
+}
+
+type filesJob struct {
+	Keys []string
+}
+
+// Create stores the contents as a new image in the gallery and records it in
+// the database, at the end of the gallery. The image is stored under a
+// sanitized version of the uploaded filename. If another image of the gallery
+// has that name but different contents, a hash of the contents is added to
+// the name. Uploading the same file again replaces the image it created,
+// keeping its position. The metadata in the file is handled as the gallery's
+// metadata policy requires.
+func (s *ImageService) Create(galleryID, userID int, uploadedName string, contents io.ReadSeeker) (*Image, error) {
+	contentType, err := checkContentType(contents, s.imageContentTypes())
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", uploadedName, err)
+	}
+	// Checked here so that the upload fails, rather than the job that
+	// processes the image.
+	err = checkDimensions(contents)
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", uploadedName, err)
+	}
+	filename := sanitizeFilename(uploadedName)
+	err = checkExtension(filename, s.extensions())
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", uploadedName, err)
+	}
+	hash, err := contentHash(contents)
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", uploadedName, err)
+	}
+
+	// The filenames of the gallery stay locked from choosing the filename
+	// until the image is recorded, so concurrent uploads can't choose the
+	// same name and overwrite each other's files.
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", uploadedName, err)
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		SELECT pg_advisory_xact_lock($1, $2);`, lockGalleryFilenames, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", uploadedName, err)
+	}
+	filename, replaces, err := uniqueFilename(tx, galleryID, filename, hash)
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", uploadedName, err)
+	}
+
+	image := Image{
+		GalleryID:        galleryID,
+		UserID:           userID,
+		Filename:         filename,
+		OriginalFilename: originalFilename(uploadedName),
+		ContentType:      contentType,
+		Key:              imageKey(galleryID, filename),
+	}
+	if contentType == "image/jpeg" {
+		md, err := readMetadata(contents)
+		// Images without valid EXIF data are stored without metadata.
+		if err == nil {
+			image.Metadata = *md
+		}
+	}
+
+	policy, err := s.metadataPolicy(galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
+	}
+	if policy == MetadataStripKeepOriginal {
+		size, err := contents.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, fmt.Errorf("creating image %v: %w", filename, err)
+		}
+		_, err = contents.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, fmt.Errorf("creating image %v: %w", filename, err)
+		}
+		image.OriginalKey = originalKey(galleryID, filename)
+		err = s.storage().Put(image.OriginalKey, contents, size)
+		if err != nil {
+			return nil, fmt.Errorf("storing original image: %w", err)
+		}
+	} else {
+		// Remove the original of an image this upload replaces.
+		err = s.storage().Delete(originalKey(galleryID, filename))
+		if err != nil {
+			return nil, fmt.Errorf("creating image %v: %w", filename, err)
+		}
+	}
+	if policy != MetadataKeep {
+		contents, err = stripMetadata(contents, contentType)
+		if err != nil {
+			return nil, fmt.Errorf("creating image %v: %w", filename, err)
+		}
+	}
+
+	image.Size, err = contents.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
+	}
+	_, err = contents.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
+	}
+
+	err = s.storage().Put(image.Key, contents, image.Size)
+	if err != nil {
+		return nil, fmt.Errorf("storing image: %w", err)
+	}
+
+	err = s.insert(tx, &image, hash)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		if !replaces {
+			s.discardFiles(&image)
+		}
+		return nil, fmt.Errorf("creating image: %w", err)
+	}
+	if s.Jobs == nil {
+		// The image is stored already, so it is shown without variants
+		// rather than reported as a failed upload, like when a queued job
+		// fails.
+		err = s.process(&image)
+		if err != nil {
+			log.Printf("processing image %d: %v", image.ID, err)
+		}
+	}
+
+	return &image, nil
+}
+
+// insert records a stored image in the database. If the ImageService has
+// Jobs, the job that processes the image is queued in the same transaction,
+// so there is never an image row without its processing job.
+func (s *ImageService) insert(tx *sql.Tx, image *Image, hash string) error {
+	md := image.Metadata
+	row := tx.QueryRow(`
+		INSERT INTO images (gallery_id, user_id, filename, original_filename,
+			content_hash, content_type, size, captured_at, camera, lens,
+			exposure_time, f_number, iso, focal_length, original_kept, position)
+		VALUES ($1, $2, $3, $14, $15, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
+			(SELECT COALESCE(MAX(position), 0) + 1 FROM images WHERE gallery_id = $1))
+		ON CONFLICT (gallery_id, filename) DO
+		UPDATE
+		SET user_id = $2, original_filename = $14, content_hash = $15,
+			content_type = $4, size = $5,
+			created_at = now(), captured_at = $6, camera = $7, lens = $8,
+			exposure_time = $9, f_number = $10, iso = $11, focal_length = $12,
+			original_kept = $13, deleted_at = NULL
+		RETURNING id, created_at, position, title, caption, alt_text,
+			COALESCE((SELECT string_agg(tag, ',' ORDER BY tag)
+				FROM image_tags
+				WHERE image_tags.image_id = images.id), '');`, image.GalleryID, image.UserID,
+		image.Filename, image.ContentType, image.Size, md.CapturedAt,
+		md.Camera, md.Lens, md.ExposureTime, md.FNumber, md.ISO, md.FocalLength,
+		image.OriginalKey != "", image.OriginalFilename, hash)
+	var tags string
+	err := row.Scan(&image.ID, &image.CreatedAt, &image.Position, &image.Title,
+		&image.Caption, &image.AltText, &tags)
+	if err != nil {
+		return err
+	}
+	image.Tags = splitTags(tags)
+
+	if s.Jobs != nil {
+		err = s.Jobs.EnqueueTx(tx, JobProcessImage, imageJob{ImageID: image.ID})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// discardFiles removes the files stored for a new image that couldn't be
+// recorded in the database. Files that can't be removed are left for the gc
+// command to report.
+func (s *ImageService) discardFiles(image *Image) {
+	for _, key := range []string{image.Key, originalKey(image.GalleryID, image.Filename)} {
+		err := s.storage().Delete(key)
+		if err != nil {
+			log.Printf("discarding files of %v: %v", image.Filename, err)
+		}
+	}
+}
+
+// HandleProcessImage is the JobHandler for JobProcessImage jobs.
+func (s *ImageService) HandleProcessImage(ctx context.Context, job *Job) error {
+	var payload imageJob
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return fmt.Errorf("process image job: %w", err)
+	}
+
+	image, err := s.ByID(payload.ImageID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			// The image was deleted before it could be processed.
+			return nil
+		}
+		return fmt.Errorf("process image job: %w", err)
+	}
+
+	return s.process(image)
+}
+
+func (s *ImageService) ByID(id int) (*Image, error) {
+	image := Image{
+		ID: id,
+	}
+
+	md := &image.Metadata
+	var originalKept bool
+	row := s.DB.QueryRow(`
+		SELECT gallery_id, user_id, filename, original_filename, content_type,
+			size, created_at,
+			captured_at, camera, lens, exposure_time, f_number, iso, focal_length,
+			original_kept, position, title, caption, alt_text,
+			COALESCE((SELECT string_agg(tag, ',' ORDER BY tag)
+				FROM image_tags
+				WHERE image_tags.image_id = images.id), '')
+		FROM images
+		WHERE id = $1 AND deleted_at IS NULL;`, image.ID)
+	var tags string
+	err := row.Scan(&image.GalleryID, &image.UserID, &image.Filename,
+		&image.OriginalFilename, &image.ContentType, &image.Size, &image.CreatedAt, &md.CapturedAt,
+		&md.Camera, &md.Lens, &md.ExposureTime, &md.FNumber, &md.ISO,
+		&md.FocalLength, &originalKept, &image.Position, &image.Title,
+		&image.Caption, &image.AltText, &tags)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query image by id: %w", err)
+	}
+	image.Key = imageKey(image.GalleryID, image.Filename)
+	if originalKept {
+		image.OriginalKey = originalKey(image.GalleryID, image.Filename)
+	}
+	image.Tags = splitTags(tags)
+
+	return &image, nil
+}
+
+// ByGalleryID returns all images in the gallery in the given order.
+func (s *ImageService) ByGalleryID(galleryID int, order ImageOrder) ([]Image, error) {
+	var orderBy string
+	switch order {
+	case OrderUploaded:
+		orderBy = "created_at, id"
+	case OrderCaptured:
+		orderBy = "captured_at NULLS LAST, created_at, id"
+	default:
+		orderBy = "position, id"
+	}
+	rows, err := s.DB.Query(`
+		SELECT id, user_id, filename, original_filename, content_type, size,
+			created_at,
+			captured_at, camera, lens, exposure_time, f_number, iso, focal_length,
+			original_kept, position, title, caption, alt_text,
+			COALESCE((SELECT string_agg(tag, ',' ORDER BY tag)
+				FROM image_tags
+				WHERE image_tags.image_id = images.id), '')
+		FROM images
+		WHERE gallery_id = $1 AND deleted_at IS NULL
+		ORDER BY `+orderBy+`;`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("query images by gallery id: %w", err)
+	}
+	defer rows.Close()
+
+	var images []Image
+	for rows.Next() {
+		image := Image{
+			GalleryID: galleryID,
+		}
+		md := &image.Metadata
+		var originalKept bool
+		var tags string
+		err = rows.Scan(&image.ID, &image.UserID, &image.Filename,
+			&image.OriginalFilename, &image.ContentType, &image.Size, &image.CreatedAt, &md.CapturedAt,
+			&md.Camera, &md.Lens, &md.ExposureTime, &md.FNumber, &md.ISO,
+			&md.FocalLength, &originalKept, &image.Position, &image.Title,
+			&image.Caption, &image.AltText, &tags)
+		if err != nil {
+			return nil, fmt.Errorf("query images by gallery id: %w", err)
+		}
+		image.Key = imageKey(galleryID, image.Filename)
+		if originalKept {
+			image.OriginalKey = originalKey(galleryID, image.Filename)
+		}
+		image.Tags = splitTags(tags)
+		images = append(images, image)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("query images by gallery id: %w", err)
+	}
+
+	return images, nil
+}
+
+// Update saves the title, caption, alt text and tags of the image.
+func (s *ImageService) Update(image *Image) error {
+	image.Title = strings.TrimSpace(image.Title)
+	image.Caption = strings.TrimSpace(image.Caption)
+	image.AltText = strings.TrimSpace(image.AltText)
+	switch {
+	case utf8.RuneCountInString(image.Title) > MaxTitleLength:
+		return ErrImageTitleTooLong
+	case utf8.RuneCountInString(image.Caption) > MaxCaptionLength:
+		return ErrCaptionTooLong
+	case utf8.RuneCountInString(image.AltText) > MaxAltTextLength:
+		return ErrAltTextTooLong
+	}
+	tags, err := normalizeTags(image.Tags)
+	if err != nil {
+		return fmt.Errorf("update image: %w", err)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("update image: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE images
+		SET title = $2, caption = $3, alt_text = $4
+		WHERE id = $1;`, image.ID, image.Title, image.Caption, image.AltText)
+	if err != nil {
+		return fmt.Errorf("update image: %w", err)
+	}
+	err = saveTags(tx, "image_tags", "image_id", image.ID, tags)
+	if err != nil {
+		return fmt.Errorf("update image: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("update image: %w", err)
+	}
+	sort.Strings(tags)
+	image.Tags = tags
+
+	return nil
+}
+
+// Reorder changes the order of the gallery's images to the order of ids,
+// which must contain the ID of every image in the gallery exactly once.
+// Otherwise ErrInvalidOrder is returned and the order is left unchanged.
+func (s *ImageService) Reorder(galleryID int, ids []int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("reorder images: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	row := tx.QueryRow(`
+		SELECT count(*)
+		FROM images
+		WHERE gallery_id = $1 AND deleted_at IS NULL;`, galleryID)
+	err = row.Scan(&count)
+	if err != nil {
+		return fmt.Errorf("reorder images: %w", err)
+	}
+	if count != len(ids) {
+		return ErrInvalidOrder
+	}
+
+	seen := make(map[int]bool, len(ids))
+	for i, id := range ids {
+		if seen[id] {
+			return ErrInvalidOrder
+		}
+		seen[id] = true
+		res, err := tx.Exec(`
+			UPDATE images
+			SET position = $3
+			WHERE id = $1 AND gallery_id = $2 AND deleted_at IS NULL;`, id, galleryID, i+1)
+		if err != nil {
+			return fmt.Errorf("reorder images: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("reorder images: %w", err)
+		}
+		if n == 0 {
+			return ErrInvalidOrder
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("reorder images: %w", err)
+	}
+
+	return nil
+}
+
+// Delete moves the image to the trash. Its files are kept until the
+// TrashService purges it.
+func (s *ImageService) Delete(id int) error {
+	res, err := s.DB.Exec(`
+		UPDATE images
+		SET deleted_at = now()
+		WHERE id = $1 AND deleted_at IS NULL;`, id)
+	if err != nil {
+		return fmt.Errorf("deleting image: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("deleting image: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("deleting image: %w", ErrNotFound)
+	}
+
+	return nil
+}
+
+// purge removes the image for good, if it is still in the trash and was
+// moved there before the given time. Its files are removed by a job queued in
+// the same transaction, or right away if the ImageService has no Jobs.
+func (s *ImageService) purge(image *Image, deletedBefore time.Time) error {
+	keys := []string{image.Key}
+	if image.OriginalKey != "" {
+		keys = append(keys, image.OriginalKey)
+	}
+	keys = append(keys, s.variantKeys(image)...)
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("purging image: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		DELETE FROM images
+		WHERE id = $1 AND deleted_at < $2;`, image.ID, deletedBefore)
+	if err != nil {
+		return fmt.Errorf("purging image: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("purging image: %w", err)
+	}
+	if n == 0 {
+		// The image was restored in the meantime.
+		return nil
+	}
+	if s.Jobs != nil {
+		err = s.Jobs.EnqueueTx(tx, JobDeleteFiles, filesJob{Keys: keys})
+		if err != nil {
+			return fmt.Errorf("purging image: %w", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("purging image: %w", err)
+	}
+
+	if s.Jobs == nil {
+		err = s.deleteFiles(keys)
+		if err != nil {
+			return fmt.Errorf("purging image: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// HandleDeleteFiles is the JobHandler for JobDeleteFiles jobs.
+func (s *ImageService) HandleDeleteFiles(ctx context.Context, job *Job) error {
+	var payload filesJob
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return fmt.Errorf("delete files job: %w", err)
+	}
+
+	return s.deleteFiles(payload.Keys)
+}
+
+func (s *ImageService) deleteFiles(keys []string) error {
+	for _, key := range keys {
+		err := s.storage().Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Open opens the stored image file for reading. Callers must close the
+// returned file.
+func (s *ImageService) Open(image *Image) (io.ReadSeekCloser, error) {
+	f, err := s.storage().Get(image.Key)
+	if err != nil {
+		return nil, fmt.Errorf("opening image: %w", err)
+	}
+
+	return f, nil
+}
+
+// OpenOriginal opens the file of the image as it was uploaded, including all
+// of its metadata. ErrNotFound is returned if it wasn't kept. Callers must
+// close the returned file.
+func (s *ImageService) OpenOriginal(image *Image) (io.ReadSeekCloser, error) {
+	if image.OriginalKey == "" {
+		return nil, ErrNotFound
+	}
+	f, err := s.storage().Get(image.OriginalKey)
+	if err != nil {
+		return nil, fmt.Errorf("opening original image: %w", err)
+	}
+
+	return f, nil
+}
+
+// OpenVariant opens the stored variant for reading. Callers must close the
+// returned file.
+func (s *ImageService) OpenVariant(variant Variant) (io.ReadSeekCloser, error) {
+	f, err := s.storage().Get(variant.Key)
+	if err != nil {
+		return nil, fmt.Errorf("opening %v variant: %w", variant.Size, err)
+	}
+
+	return f, nil
+}
+
+// Reconcile imports image files that exist in the storage but have no row in
+// the images table, such as images uploaded before images were tracked in the
+// database. Imported images are attributed to the gallery owner. Files of
+// galleries that no longer exist are ignored. Reconcile returns the number of
+// images imported.
+func (s *ImageService) Reconcile() (int, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, user_id
+		FROM galleries;`)
+	if err != nil {
+		return 0, fmt.Errorf("reconcile images: %w", err)
+	}
+	defer rows.Close()
+
+	owners := make(map[int]int)
+	for rows.Next() {
+		var galleryID, userID int
+		err = rows.Scan(&galleryID, &userID)
+		if err != nil {
+			return 0, fmt.Errorf("reconcile images: %w", err)
+		}
+		owners[galleryID] = userID
+	}
+	err = rows.Err()
+	if err != nil {
+		return 0, fmt.Errorf("reconcile images: %w", err)
+	}
+
+	var imported int
+	for galleryID, userID := range owners {
+		n, err := s.reconcileGallery(galleryID, userID)
+		imported += n
+		if err != nil {
+			return imported, fmt.Errorf("reconcile images: %w", err)
+		}
+	}
+
+	return imported, nil
+}
+
+func (s *ImageService) reconcileGallery(galleryID, userID int) (int, error) {
+	objects, err := s.storage().List(galleryPrefix(galleryID))
+	if err != nil {
+		return 0, fmt.Errorf("gallery-%d: %w", galleryID, err)
+	}
+
+	var imported int
+	for _, obj := range objects {
+		filename := strings.TrimPrefix(obj.Key, galleryPrefix(galleryID))
+		if strings.Contains(filename, "/") || !hasExtension(filename, s.extensions()) {
+			continue
+		}
+		contentType, err := s.objectContentType(obj.Key)
+		if err != nil {
+			var fileErr FileError
+			if errors.As(err, &fileErr) {
+				continue
+			}
+			return imported, fmt.Errorf("gallery-%d: %w", galleryID, err)
+		}
+
+		res, err := s.DB.Exec(`
+			INSERT INTO images (gallery_id, user_id, filename, original_filename,
+				content_type, size, created_at, position)
+			VALUES ($1, $2, $3, $3, $4, $5, $6,
+				(SELECT COALESCE(MAX(position), 0) + 1 FROM images WHERE gallery_id = $1))
+			ON CONFLICT (gallery_id, filename) DO NOTHING;`, galleryID, userID,
+			filename, contentType, obj.Size, obj.ModTime)
+		if err != nil {
+			return imported, fmt.Errorf("gallery-%d: %w", galleryID, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return imported, fmt.Errorf("gallery-%d: %w", galleryID, err)
+		}
+		imported += int(n)
+	}
+
+	return imported, nil
+}
+
+func (s *ImageService) objectContentType(key string) (string, error) {
+	f, err := s.storage().Get(key)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return checkContentType(f, s.imageContentTypes())
+}
+
+func (s *ImageService) extensions() []string {
+	return []string{".png", ".jpg", ".jpeg", ".gif"}
+}
+
+func (s *ImageService) imageContentTypes() []string {
+	return []string{"image/png", "image/jpeg", "image/gif"}
+}
+
+func (s *ImageService) storage() Storage {
+	if s.Storage == nil {
+		return DefaultStorage
+	}
+	return s.Storage
+}
+
+// imageKey returns the storage key of an image file.
+func imageKey(galleryID int, filename string) string {
+	return galleryPrefix(galleryID) + filename
+}
@@ -0,0 +1,271 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	JobSendEmail          = "send_email"
+	JobProcessImage       = "process_image"
+	JobDeleteGalleryFiles = "delete_gallery_files"
+	JobDeleteFiles        = "delete_files"
+	// JobSendPasswordReset and JobSendEmailVerification jobs only store the
+	// ID of the token's row. The token is issued when the email is sent.
+	JobSendPasswordReset     = "send_password_reset"
+	JobSendEmailVerification = "send_email_verification"
+)
+
+const (
+	DefaultJobWorkers      = 4
+	DefaultJobMaxAttempts  = 5
+	DefaultJobPollInterval = 1 * time.Second
+	// DefaultJobTimeout is how long a job may stay running before it is
+	// assumed that its worker died and the job is queued again.
+	DefaultJobTimeout = 10 * time.Minute
+)
+
+type Job struct {
+	ID          int64
+	Kind        string
+	Payload     json.RawMessage
+	Attempts    int
+	MaxAttempts int
+}
+
+// JobHandler performs a job. If it returns an error the job is retried with
+// an increasing delay until it runs out of attempts.
+type JobHandler func(ctx context.Context, job *Job) error
+
+// JobService stores jobs in the database and runs them in a pool of workers.
+// Jobs survive restarts: anything that was queued or running when the server
+// stopped is picked up again by the next call to Run.
+type JobService struct {
+	DB *sql.DB
+	// Workers is the number of jobs that are run concurrently. Defaults to
+	// DefaultJobWorkers.
+	Workers int
+	// PollInterval is how long an idle worker waits before checking for new
+	// jobs. Defaults to DefaultJobPollInterval.
+	PollInterval time.Duration
+
+	handlers map[string]JobHandler
+}
+
+// Handle registers the handler for jobs of the given kind. Handlers must be
+// registered before Run is called.
+func (js *JobService) Handle(kind string, handler JobHandler) {
+	if js.handlers == nil {
+		js.handlers = make(map[string]JobHandler)
+	}
+	js.handlers[kind] = handler
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Enqueue adds a job to the queue. The payload is stored as JSON and handed
+// to the job's handler when it runs.
+func (js *JobService) Enqueue(kind string, payload interface{}) error {
+	return js.enqueue(js.DB, kind, payload)
+}
+
+// EnqueueTx adds a job to the queue as part of the transaction. The job only
+// runs if the transaction is committed, so it can be used as an outbox for
+// work, such as removing files, that must follow a change in the database.
+func (js *JobService) EnqueueTx(tx *sql.Tx, kind string, payload interface{}) error {
+	return js.enqueue(tx, kind, payload)
+}
+
+func (js *JobService) enqueue(db execer, kind string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("enqueue %v job: %w", kind, err)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO jobs (kind, payload, max_attempts)
+		VALUES ($1, $2, $3);`, kind, data, DefaultJobMaxAttempts)
+	if err != nil {
+		return fmt.Errorf("enqueue %v job: %w", kind, err)
+	}
+
+	return nil
+}
+
+// Run starts the workers and blocks until ctx is canceled and every worker
+// has finished its current job.
+func (js *JobService) Run(ctx context.Context) {
+	workers := js.Workers
+	if workers <= 0 {
+		workers = DefaultJobWorkers
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		js.watchStale(ctx)
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			js.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (js *JobService) work(ctx context.Context) {
+	pollInterval := js.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultJobPollInterval
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		job, err := js.dequeue()
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+		}
+		if job != nil {
+			// A job that has started is finished even if ctx is canceled
+			// meanwhile, so that it doesn't fail and use up an attempt.
+			js.run(context.WithoutCancel(ctx), job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// dequeue claims the next job that is due. Rows locked by other workers are
+// skipped so that workers never block each other.
+func (js *JobService) dequeue() (*Job, error) {
+	var job Job
+	row := js.DB.QueryRow(`
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_at = now()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = 'queued' AND run_at <= now()
+			ORDER BY run_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, kind, payload, attempts, max_attempts;`)
+	err := row.Scan(&job.ID, &job.Kind, &job.Payload, &job.Attempts, &job.MaxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("dequeue job: %w", err)
+	}
+
+	return &job, nil
+}
+
+func (js *JobService) run(ctx context.Context, job *Job) {
+	jobErr := js.handle(ctx, job)
+	if jobErr == nil {
+		_, err := js.DB.Exec(`
+			DELETE FROM jobs
+			WHERE id = $1;`, job.ID)
+		if err != nil {
+			log.Printf("complete job %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("%v job %d failed (attempt %d of %d): %v",
+		job.Kind, job.ID, job.Attempts, job.MaxAttempts, jobErr)
+	var err error
+	if job.Attempts >= job.MaxAttempts {
+		_, err = js.DB.Exec(`
+			UPDATE jobs
+			SET status = 'failed', last_error = $2, locked_at = NULL
+			WHERE id = $1;`, job.ID, jobErr.Error())
+	} else {
+		_, err = js.DB.Exec(`
+			UPDATE jobs
+			SET status = 'queued', last_error = $2, locked_at = NULL,
+				run_at = now() + $3::float8 * interval '1 second'
+			WHERE id = $1;`, job.ID, jobErr.Error(), jobBackoff(job.Attempts).Seconds())
+	}
+	if err != nil {
+		log.Printf("reschedule job %d: %v", job.ID, err)
+	}
+}
+
+func (js *JobService) handle(ctx context.Context, job *Job) (err error) {
+	handler, ok := js.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for %v jobs", job.Kind)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return handler(ctx, job)
+}
+
+// watchStale periodically requeues stale jobs until ctx is canceled.
+func (js *JobService) watchStale(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		err := js.requeueStale()
+		if err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// requeueStale puts jobs back in the queue whose worker stopped without
+// reporting a result, e.g. because the server was restarted mid-job.
+func (js *JobService) requeueStale() error {
+	_, err := js.DB.Exec(`
+		UPDATE jobs
+		SET status = 'queued', locked_at = NULL
+		WHERE status = 'running' AND locked_at < now() - $1::float8 * interval '1 second';`,
+		DefaultJobTimeout.Seconds())
+	if err != nil {
+		return fmt.Errorf("requeue stale jobs: %w", err)
+	}
+
+	return nil
+}
+
+// jobBackoff returns how long to wait before retrying a job that has failed
+// the given number of times.
+func jobBackoff(attempts int) time.Duration {
+	backoff := 10 * time.Second
+	for i := 1; i < attempts && backoff < time.Hour; i++ {
+		backoff *= 2
+	}
+	if backoff > time.Hour {
+		backoff = time.Hour
+	}
+
+	return backoff
+}
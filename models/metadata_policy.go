@@ -0,0 +1,360 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+// Metadata policies decide what happens to the metadata embedded in images
+// uploaded to a gallery. Phones record where a photo was taken and cameras
+// record their serial numbers, which visitors of a gallery shouldn't learn.
+const (
+	// MetadataStrip removes location data and serial numbers before an image
+	// is stored. Capture time and camera settings are kept.
+	MetadataStrip = "strip"
+	// MetadataStripKeepOriginal serves images like MetadataStrip, but also
+	// keeps the uploaded file so that the owner can download it.
+	MetadataStripKeepOriginal = "strip_keep_original"
+	// MetadataKeep stores and serves images exactly as they were uploaded.
+	MetadataKeep = "keep"
+)
+
+// MetadataPolicies lists every valid metadata policy.
+var MetadataPolicies = []string{
+	MetadataStrip,
+	MetadataStripKeepOriginal,
+	MetadataKeep,
+}
+
+// SetMetadataPolicy changes the metadata policy of the gallery. The policy
+// only applies to images uploaded afterwards.
+func (s *GalleryService) SetMetadataPolicy(gallery *Gallery, policy string) error {
+	if !validMetadataPolicy(policy) {
+		return ErrInvalidMetadataPolicy
+	}
+
+	_, err := s.DB.Exec(`
+		UPDATE galleries
+		SET metadata_policy = $2
+		WHERE id = $1;`, gallery.ID, policy)
+	if err != nil {
+		return fmt.Errorf("set metadata policy: %w", err)
+	}
+	gallery.MetadataPolicy = policy
+
+	return nil
+}
+
+func validMetadataPolicy(policy string) bool {
+	for _, p := range MetadataPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataPolicy returns the metadata policy of the gallery.
+func (s *ImageService) metadataPolicy(galleryID int) (string, error) {
+	var policy string
+	row := s.DB.QueryRow(`
+		SELECT metadata_policy
+		FROM galleries
+		WHERE id = $1;`, galleryID)
+	err := row.Scan(&policy)
+	if err != nil {
+		return "", fmt.Errorf("query metadata policy: %w", err)
+	}
+
+	return policy, nil
+}
+
+// originalKey returns the storage key of the uploaded file of an image whose
+// metadata was stripped. Reconcile ignores it, as it is in a folder.
+func originalKey(galleryID int, filename string) string {
+	return galleryPrefix(galleryID) + "originals/" + filename
+}
+
+// StripStoredMetadata strips the stored images of galleries that don't keep
+// metadata again, with the current rules. Images stored before a rule was
+// added, or while their gallery kept metadata, are served with the metadata
+// it removes until this is run. Kept originals aren't changed.
+// StripStoredMetadata returns the number of images that changed.
+func (s *ImageService) StripStoredMetadata() (int, error) {
+	rows, err := s.DB.Query(`
+		SELECT images.id, images.gallery_id, images.filename, images.content_type
+		FROM images
+			JOIN galleries ON galleries.id = images.gallery_id
+		WHERE galleries.metadata_policy <> $1
+		ORDER BY images.id;`, MetadataKeep)
+	if err != nil {
+		return 0, fmt.Errorf("strip stored metadata: %w", err)
+	}
+	defer rows.Close()
+
+	var images []Image
+	for rows.Next() {
+		var image Image
+		err = rows.Scan(&image.ID, &image.GalleryID, &image.Filename, &image.ContentType)
+		if err != nil {
+			return 0, fmt.Errorf("strip stored metadata: %w", err)
+		}
+		image.Key = imageKey(image.GalleryID, image.Filename)
+		images = append(images, image)
+	}
+	err = rows.Err()
+	if err != nil {
+		return 0, fmt.Errorf("strip stored metadata: %w", err)
+	}
+
+	var stripped int
+	for i := range images {
+		changed, err := s.restrip(&images[i])
+		if err != nil {
+			var fileErr FileError
+			if errors.As(err, &fileErr) || errors.Is(err, ErrNotFound) {
+				log.Printf("strip stored metadata: skipping %v: %v", images[i].Key, err)
+				continue
+			}
+			return stripped, fmt.Errorf("strip stored metadata: %w", err)
+		}
+		if changed {
+			stripped++
+		}
+	}
+
+	return stripped, nil
+}
+
+// restrip strips the stored file of the image and replaces it if that changed
+// anything.
+func (s *ImageService) restrip(image *Image) (bool, error) {
+	f, err := s.storage().Get(image.Key)
+	if err != nil {
+		return false, err
+	}
+	stored, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return false, err
+	}
+
+	r, err := stripMetadata(bytes.NewReader(stored), image.ContentType)
+	if err != nil {
+		return false, err
+	}
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(contents, stored) {
+		return false, nil
+	}
+
+	err = s.storage().Put(image.Key, bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		return false, err
+	}
+	_, err = s.DB.Exec(`
+		UPDATE images
+		SET size = $2
+		WHERE id = $1;`, image.ID, len(contents))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// EXIF tags that identify the photographer or their equipment.
+var privateExifTags = map[uint16]bool{
+	0x927c: true, // MakerNote, which contains serial numbers and more
+	0xa420: true, // ImageUniqueID
+	0xa430: true, // CameraOwnerName
+	0xa431: true, // BodySerialNumber
+	0xa435: true, // LensSerialNumber
+	0xc62f: true, // CameraSerialNumber
+}
+
+// stripMetadata returns a copy of the image without location data and serial
+// numbers. GIFs don't carry such metadata and are returned as they are.
+// r is rewound to the start before returning.
+func stripMetadata(r io.ReadSeeker, contentType string) (io.ReadSeeker, error) {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Seek(0, io.SeekStart)
+
+	var buf bytes.Buffer
+	switch contentType {
+	case "image/jpeg":
+		err = stripJPEG(&buf, r)
+	case "image/png":
+		err = stripPNG(&buf, r)
+	default:
+		return r, nil
+	}
+	if err != nil {
+		return nil, FileError{
+			Issue: fmt.Sprintf("unreadable metadata: %v", err),
+		}
+	}
+
+	return bytes.NewReader(buf.Bytes()), nil
+}
+
+// stripJPEG copies the JPEG from r to w. Location data and serial numbers
+// are erased from the EXIF data, or the EXIF data is dropped if it can't be
+// parsed. XMP and IPTC segments, which may repeat them, are dropped.
+func stripJPEG(w io.Writer, r io.Reader) error {
+	jr, err := newJPEGReader(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte{0xff, markerSOI})
+	if err != nil {
+		return err
+	}
+
+	for {
+		marker, segment, err := jr.next()
+		if err != nil {
+			return err
+		}
+		if segment == nil {
+			_, err = w.Write([]byte{0xff, marker})
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, jr.br)
+			return err
+		}
+
+		switch {
+		case marker == markerAPP1 && bytes.HasPrefix(segment, exifHeader):
+			x, err := parseExif(segment[len(exifHeader):])
+			if err != nil || !x.erasePrivate() {
+				// The EXIF data is damaged or the location can't be erased
+				// reliably, so it is dropped. Its metadata was read before
+				// stripping.
+				continue
+			}
+		case marker == markerAPP1, marker == markerAPP13:
+			// Other APP1 segments hold XMP, APP13 holds IPTC.
+			continue
+		}
+
+		var header [4]byte
+		header[0] = 0xff
+		header[1] = marker
+		binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+		_, err = w.Write(header[:])
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(segment)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// erasePrivate overwrites the GPS IFD, the values of its entries and the
+// values of privateExifTags with zeros. The layout of the EXIF data stays the
+// same, so no offsets change. It reports false if the GPS IFD has entries
+// whose values couldn't be located, which may still hold location data.
+func (x *exifData) erasePrivate() bool {
+	for _, e := range x.gps {
+		x.zero(e)
+	}
+	for _, entries := range [][]exifEntry{x.ifd0, x.exif} {
+		for _, e := range entries {
+			if privateExifTags[e.tag] {
+				x.zero(e)
+			}
+		}
+	}
+	if x.gpsOffset != 0 {
+		// An empty IFD without a next IFD.
+		n := uint32(len(x.gps))
+		end := x.gpsOffset + 2 + n*12 + 4
+		if uint64(end) > uint64(len(x.tiff)) {
+			end = uint32(len(x.tiff))
+		}
+		clear(x.tiff[x.gpsOffset:end])
+	}
+
+	return x.gpsSkipped == 0
+}
+
+// zero overwrites the value of the entry.
+func (x *exifData) zero(e exifEntry) {
+	size := typeSizes[e.typ] * e.count
+	clear(x.tiff[e.offset : e.offset+size])
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// privatePNGChunks are the chunks that may hold location data. Text chunks
+// are included because XMP is stored in them.
+var privatePNGChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+}
+
+// stripPNG copies the PNG from r to w without the chunks in
+// privatePNGChunks. Each chunk has its own checksum, so the remaining chunks
+// are copied unchanged.
+func stripPNG(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	signature := make([]byte, len(pngSignature))
+	_, err := io.ReadFull(br, signature)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(signature, pngSignature) {
+		return fmt.Errorf("not a png")
+	}
+	_, err = w.Write(signature)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var header [8]byte
+		_, err = io.ReadFull(br, header[:])
+		if err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:])
+		// The chunk data is followed by a 4 byte CRC.
+		if privatePNGChunks[typ] {
+			_, err = br.Discard(int(length + 4))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err = w.Write(header[:])
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(w, br, length+4)
+		if err != nil {
+			return err
+		}
+		if typ == "IEND" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestStripJPEG(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			// The values of the GPS entries are long enough to be stored out
+			// of line, so they remain in the file unless they are erased.
+			gps := []tiffEntry{
+				{tag: 0x0000, typ: typeByte, count: 4, value: []byte{2, 3, 0, 0}},
+				asciiEntry(0x0001, "GPS-REF"),
+				{tag: 0x0002, typ: typeRational, count: 3, value: []byte("GPS-RATIONAL-LATITUDE01")},
+				{tag: 0x0003, typ: typeSByte, count: 8, value: []byte("GPS-SB01")},
+				{tag: 0x0004, typ: typeSShort, count: 4, value: []byte("GPS-SS01")},
+				{tag: 0x0005, typ: typeFloat, count: 2, value: []byte("GPS-FL01")},
+				{tag: 0x0006, typ: typeDouble, count: 1, value: []byte("GPS-DB01")},
+				{tag: 0x0007, typ: typeSRational, count: 1, value: []byte("GPS-SR01")},
+			}
+			tiff := buildTIFF(order,
+				[]tiffEntry{asciiEntry(tagModel, "Canon EOS R5")},
+				[]tiffEntry{
+					asciiEntry(tagDateTimeOriginal, "2023:05:01 10:20:30"),
+					asciiEntry(0xa431, "SERIAL-123456"),
+				},
+				gps)
+			xmp := jpegSegment(markerAPP1, []byte("http://ns.adobe.com/xap/1.0/\x00XMP-LOCATION"))
+			iptc := jpegSegment(markerAPP13, []byte("Photoshop 3.0\x00IPTC-LOCATION"))
+			in := testJPEG(t, exifSegment(tiff), xmp, iptc)
+
+			var out bytes.Buffer
+			err := stripJPEG(&out, bytes.NewReader(in))
+			if err != nil {
+				t.Fatalf("stripJPEG() err = %v", err)
+			}
+			for _, private := range []string{"GPS-", "SERIAL-", "XMP-", "IPTC-"} {
+				if bytes.Contains(out.Bytes(), []byte(private)) {
+					t.Errorf("stripped jpeg contains %q", private)
+				}
+			}
+			if out.Len() != len(in)-len(xmp)-len(iptc) {
+				t.Errorf("stripped jpeg is %d bytes, want %d", out.Len(), len(in)-len(xmp)-len(iptc))
+			}
+
+			md, err := readMetadata(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatalf("readMetadata() err = %v", err)
+			}
+			if md.Camera != "Canon EOS R5" || md.CapturedAt == nil {
+				t.Errorf("metadata = %+v, want the camera and capture time to be kept", md)
+			}
+			tiff, err = readJPEGExif(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatalf("readJPEGExif() err = %v", err)
+			}
+			x, err := parseExif(tiff)
+			if err != nil {
+				t.Fatalf("parseExif() err = %v", err)
+			}
+			if len(x.gps) != 0 {
+				t.Errorf("gps ifd has %d entries, want 0", len(x.gps))
+			}
+			_, err = jpeg.Decode(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Errorf("jpeg.Decode() err = %v", err)
+			}
+		})
+	}
+}
+
+func TestStripJPEGDropsExifWithUnknownGPSEntries(t *testing.T) {
+	tests := map[string][]tiffEntry{
+		"unknown type": {
+			{tag: 0x0002, typ: 99, count: 3, value: []byte("GPS-UNKNOWN-TYPE")},
+		},
+		"value out of bounds": {
+			{tag: 0x0002, typ: typeRational, count: 1000, value: []byte("GPS-OUT-OF-BOUNDS")},
+		},
+	}
+	for name, gps := range tests {
+		t.Run(name, func(t *testing.T) {
+			tiff := buildTIFF(binary.BigEndian, []tiffEntry{asciiEntry(tagModel, "Canon EOS R5")}, nil, gps)
+			in := testJPEG(t, exifSegment(tiff))
+
+			var out bytes.Buffer
+			err := stripJPEG(&out, bytes.NewReader(in))
+			if err != nil {
+				t.Fatalf("stripJPEG() err = %v", err)
+			}
+			if bytes.Contains(out.Bytes(), exifHeader) {
+				t.Error("stripped jpeg contains exif data")
+			}
+			if bytes.Contains(out.Bytes(), []byte("GPS-")) {
+				t.Error("stripped jpeg contains gps data")
+			}
+			_, err = jpeg.Decode(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Errorf("jpeg.Decode() err = %v", err)
+			}
+		})
+	}
+}
+
+func TestStripJPEGDropsUnreadableExif(t *testing.T) {
+	tiff := buildTIFF(binary.LittleEndian,
+		[]tiffEntry{asciiEntry(tagModel, "Canon EOS R5")},
+		nil,
+		[]tiffEntry{{tag: 0x0002, typ: typeRational, count: 3, value: []byte("GPS-RATIONAL-LATITUDE01")}})
+	tests := map[string][]byte{
+		"invalid byte order": []byte("XX*\x00\x08\x00\x00\x00GPS-DATA"),
+		// Cut off in the middle of IFD0, before the pointer to the GPS IFD.
+		"truncated": tiff[:20],
+		// Cut off before the GPS IFD, whose values are still in the segment.
+		"gps ifd out of bounds": append(append([]byte(nil), tiff[:len(tiff)-40]...), "GPS-DATA"...),
+	}
+	for name, tiff := range tests {
+		t.Run(name, func(t *testing.T) {
+			in := testJPEG(t, exifSegment(tiff))
+
+			var out bytes.Buffer
+			err := stripJPEG(&out, bytes.NewReader(in))
+			if err != nil {
+				t.Fatalf("stripJPEG() err = %v", err)
+			}
+			if bytes.Contains(out.Bytes(), exifHeader) {
+				t.Error("stripped jpeg contains exif data")
+			}
+			if bytes.Contains(out.Bytes(), []byte("GPS-")) {
+				t.Error("stripped jpeg contains gps data")
+			}
+			_, err = jpeg.Decode(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Errorf("jpeg.Decode() err = %v", err)
+			}
+		})
+	}
+}
+
+func TestStripJPEGErrors(t *testing.T) {
+	valid := testJPEG(t)
+	tests := map[string][]byte{
+		"not a jpeg":        []byte("GIF89a"),
+		"truncated segment": testJPEG(t, exifSegment(buildTIFF(binary.LittleEndian, nil, nil, nil)))[:12],
+		"invalid marker":    append(append([]byte(nil), valid[:2]...), 0x00, 0x00),
+	}
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := stripJPEG(io.Discard, bytes.NewReader(in))
+			if err == nil {
+				t.Fatal("stripJPEG() err = nil, want an error")
+			}
+		})
+	}
+}
+
+// pngChunk encodes a PNG chunk with the type and data.
+func pngChunk(typ string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+}
+
+func TestStripPNG(t *testing.T) {
+	var encoded bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Pix[0] = 0xff
+	err := png.Encode(&encoded, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The IHDR chunk has 13 bytes of data and must come first.
+	ihdrEnd := len(pngSignature) + 8 + 13 + 4
+	want := encoded.Bytes()
+
+	var in []byte
+	in = append(in, want[:ihdrEnd]...)
+	in = append(in, pngChunk("tEXt", []byte("Location\x00PNG-TEXT"))...)
+	in = append(in, pngChunk("zTXt", []byte("Location\x00\x00PNG-ZTXT"))...)
+	in = append(in, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00PNG-ITXT"))...)
+	in = append(in, pngChunk("eXIf", buildTIFF(binary.BigEndian, []tiffEntry{asciiEntry(tagModel, "PNG-EXIF")}, nil, nil))...)
+	in = append(in, want[ihdrEnd:]...)
+
+	var out bytes.Buffer
+	err = stripPNG(&out, bytes.NewReader(in))
+	if err != nil {
+		t.Fatalf("stripPNG() err = %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("stripPNG() = %q, want %q", out.Bytes(), want)
+	}
+	decoded, err := png.Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("png.Decode() err = %v", err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Errorf("decoded bounds = %v, want %v", decoded.Bounds(), img.Bounds())
+	}
+}
+
+func TestStripPNGErrors(t *testing.T) {
+	var encoded bytes.Buffer
+	err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 4, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := encoded.Bytes()
+	tests := map[string][]byte{
+		"not a png":       []byte("GIF89a"),
+		"truncated chunk": valid[:len(valid)-20],
+		"no iend":         valid[:len(valid)-12],
+	}
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := stripPNG(io.Discard, bytes.NewReader(in))
+			if err == nil {
+				t.Fatal("stripPNG() err = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestStripMetadataKeepsGIFs(t *testing.T) {
+	in := []byte("GIF89a contents")
+	r, err := stripMetadata(bytes.NewReader(in), "image/gif")
+	if err != nil {
+		t.Fatalf("stripMetadata() err = %v", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, in) {
+		t.Errorf("stripMetadata() = %q, want %q", out, in)
+	}
+}
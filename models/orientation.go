@@ -0,0 +1,212 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+)
+
+// Values of the EXIF Orientation tag. They describe how the stored pixels
+// must be transformed to display the photo upright.
+const (
+	orientationNormal     = 1
+	orientationFlipH      = 2
+	orientationRotate180  = 3
+	orientationFlipV      = 4
+	orientationTranspose  = 5
+	orientationRotate90   = 6
+	orientationTransverse = 7
+	orientationRotate270  = 8
+)
+
+// jpegOrientationQuality is the quality rotated JPEGs are encoded with. It
+// is higher than the quality of variants, as the result replaces the
+// original.
+const jpegOrientationQuality = 95
+
+// process prepares a newly created image for serving.
+func (s *ImageService) process(img *Image) error {
+	err := s.applyOrientation(img)
+	if err != nil {
+		return err
+	}
+	return s.CreateVariants(img)
+}
+
+// applyOrientation rotates and flips the pixels of a JPEG as its EXIF
+// Orientation tag describes, and resets the tag. Browsers and image viewers
+// don't all honor the tag, but all of them display the result upright. The
+// other metadata of the image is kept.
+func (s *ImageService) applyOrientation(img *Image) error {
+	if img.ContentType != "image/jpeg" {
+		return nil
+	}
+	f, err := s.Open(img)
+	if err != nil {
+		return fmt.Errorf("apply orientation: %w", err)
+	}
+	defer f.Close()
+
+	segments, orientation, err := readJPEGMetadata(f)
+	if err != nil || orientation <= orientationNormal || orientation > orientationRotate270 {
+		// Images we can't read the orientation of are left as they are.
+		return nil
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("apply orientation: %w", err)
+	}
+	src, err := decodeImage(f)
+	if err != nil {
+		return fmt.Errorf("apply orientation: decoding %v: %w", img.Filename, err)
+	}
+
+	var encoded bytes.Buffer
+	err = jpeg.Encode(&encoded, orient(src, orientation), &jpeg.Options{Quality: jpegOrientationQuality})
+	if err != nil {
+		return fmt.Errorf("apply orientation: %w", err)
+	}
+	// The encoder writes no metadata, so the original segments go right
+	// after the start of image marker.
+	var buf bytes.Buffer
+	buf.Write(encoded.Bytes()[:2])
+	buf.Write(segments)
+	buf.Write(encoded.Bytes()[2:])
+
+	err = s.storage().Put(img.Key, &buf, int64(buf.Len()))
+	if err != nil {
+		return fmt.Errorf("apply orientation: %w", err)
+	}
+	img.Size = int64(buf.Len())
+	_, err = s.DB.Exec(`
+		UPDATE images
+		SET size = $2
+		WHERE id = $1;`, img.ID, img.Size)
+	if err != nil {
+		return fmt.Errorf("apply orientation: %w", err)
+	}
+
+	return nil
+}
+
+// readJPEGMetadata returns the encoded metadata segments of a JPEG and its
+// orientation. The Orientation tag in the returned segments is reset to
+// normal.
+func readJPEGMetadata(r io.Reader) ([]byte, int, error) {
+	jr, err := newJPEGReader(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var segments bytes.Buffer
+	orientation := orientationNormal
+	for {
+		marker, segment, err := jr.next()
+		if err != nil {
+			return nil, 0, err
+		}
+		if segment == nil {
+			return segments.Bytes(), orientation, nil
+		}
+		// Only application segments and comments are metadata. The others
+		// describe the encoding, which changes, and so does the Adobe
+		// segment, which records the color transform.
+		if (marker < 0xe0 || marker > 0xef) && marker != 0xfe || marker == markerAPP14 {
+			continue
+		}
+		if marker == markerAPP1 && bytes.HasPrefix(segment, exifHeader) {
+			x, err := parseExif(segment[len(exifHeader):])
+			if err == nil {
+				orientation = x.resetOrientation()
+			}
+		}
+
+		var header [4]byte
+		header[0] = 0xff
+		header[1] = marker
+		binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+		segments.Write(header[:])
+		segments.Write(segment)
+	}
+}
+
+// resetOrientation sets the Orientation tag to normal and returns its
+// previous value.
+func (x *exifData) resetOrientation() int {
+	e, ok := find(x.ifd0, tagOrientation)
+	if !ok || e.typ != typeShort {
+		return orientationNormal
+	}
+	orientation := int(x.long(e))
+	x.order.PutUint16(x.tiff[e.offset:], orientationNormal)
+
+	return orientation
+}
+
+// orient returns a copy of src transformed as the EXIF orientation
+// describes. Pixels are read from src as they are copied, so the result is
+// the only other copy of the image in memory.
+func orient(src image.Image, orientation int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// dst maps a pixel of the stored image to its position in the upright
+	// image.
+	var dst func(x, y int) (int, int)
+	switch orientation {
+	case orientationFlipH:
+		dst = func(x, y int) (int, int) { return w - 1 - x, y }
+	case orientationRotate180:
+		dst = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case orientationFlipV:
+		dst = func(x, y int) (int, int) { return x, h - 1 - y }
+	case orientationTranspose:
+		dst = func(x, y int) (int, int) { return y, x }
+	case orientationRotate90:
+		dst = func(x, y int) (int, int) { return h - 1 - y, x }
+	case orientationTransverse:
+		dst = func(x, y int) (int, int) { return h - 1 - y, w - 1 - x }
+	case orientationRotate270:
+		dst = func(x, y int) (int, int) { return y, w - 1 - x }
+	default:
+		return src
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	if orientation >= orientationTranspose {
+		out = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+	at := rgbaAt(src)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := dst(x, y)
+			c := at(b.Min.X+x, b.Min.Y+y)
+			j := out.PixOffset(dx, dy)
+			out.Pix[j+0] = c.R
+			out.Pix[j+1] = c.G
+			out.Pix[j+2] = c.B
+			out.Pix[j+3] = c.A
+		}
+	}
+
+	return out
+}
+
+// rgbaAt returns a function that reads a pixel of img. JPEGs usually decode
+// to *image.YCbCr, which is read without going through the color.Color
+// interface.
+func rgbaAt(img image.Image) func(x, y int) color.RGBA {
+	if ycc, ok := img.(*image.YCbCr); ok {
+		return func(x, y int) color.RGBA {
+			c := ycc.YCbCrAt(x, y)
+			r, g, b := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+			return color.RGBA{R: r, G: g, B: b, A: 0xff}
+		}
+	}
+	return func(x, y int) color.RGBA {
+		return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	}
+}
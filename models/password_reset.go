@@ -15,6 +15,8 @@ const (
 type PasswordReset struct {
 	ID     int
 	UserID int
+	// Email is the address of the user, which the reset link is sent to.
+	Email string
 	// Token is only set when a PasswordReset is being created.
 	Token     string
 	TokenHash string
@@ -56,6 +58,7 @@ func (p *PasswordResetService) Create(email string) (*PasswordReset, error) {
 	}
 	pwReset := PasswordReset{
 		UserID:    userID,
+		Email:     email,
 		Token:     token,
 		TokenHash: tokenHash,
 		ExpiresAt: time.Now().Add(duration),
@@ -76,6 +79,38 @@ func (p *PasswordResetService) Create(email string) (*PasswordReset, error) {
 	return &pwReset, nil
 }
 
+// Reissue replaces the token of the password reset with a new one, so that a
+// link to it can be sent when the token itself wasn't kept. It returns
+// ErrNotFound if the password reset was used or has expired.
+func (p *PasswordResetService) Reissue(id int) (*PasswordReset, error) {
+	token, tokenHash, err := p.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("reissue password reset: %w", err)
+	}
+	pwReset := PasswordReset{
+		ID:        id,
+		Token:     token,
+		TokenHash: tokenHash,
+	}
+
+	row := p.DB.QueryRow(`
+		UPDATE password_resets
+		SET token_hash = $2
+		FROM users
+		WHERE password_resets.id = $1 AND password_resets.expires_at > now()
+			AND users.id = password_resets.user_id
+		RETURNING users.id, users.email, password_resets.expires_at;`, id, tokenHash)
+	err = row.Scan(&pwReset.UserID, &pwReset.Email, &pwReset.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("reissue password reset: %w", err)
+	}
+
+	return &pwReset, nil
+}
+
 func (p *PasswordResetService) Consume(token string) (*User, error) {
 	tokenHash := p.TokenManager.Hash(token)
 	var user User
@@ -120,3 +155,15 @@ func (p *PasswordResetService) delete(id int) error {
 
 	return nil
 }
+
+// DeleteExpired deletes every password reset that has expired.
+func (p *PasswordResetService) DeleteExpired() error {
+	_, err := p.DB.Exec(`
+		DELETE FROM password_resets
+		WHERE expires_at <= now();`)
+	if err != nil {
+		return fmt.Errorf("delete expired password resets: %w", err)
+	}
+
+	return nil
+}
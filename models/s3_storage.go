@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	// Endpoint is the host (and optional port) of the S3-compatible service,
+	// e.g. "s3.amazonaws.com" or "localhost:9000" for a local MinIO.
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	// Secure determines whether HTTPS is used to talk to the endpoint.
+	Secure bool
+}
+
+// S3Storage stores objects in a bucket of an S3-compatible object store.
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Storage connects to the object store described by cfg and creates the
+// bucket if it does not exist yet.
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.Secure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("new s3 storage: %w", err)
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("new s3 storage: %w", err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{
+			Region: cfg.Region,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("new s3 storage: %w", err)
+		}
+	}
+
+	return &S3Storage{
+		client: client,
+		bucket: cfg.Bucket,
+	}, nil
+}
+
+func (ss *S3Storage) Put(key string, r io.Reader, size int64) error {
+	_, err := ss.client.PutObject(context.Background(), ss.bucket, key, r, size,
+		minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("put %v: %w", key, err)
+	}
+
+	return nil
+}
+
+func (ss *S3Storage) Get(key string) (io.ReadSeekCloser, error) {
+	obj, err := ss.client.GetObject(context.Background(), ss.bucket, key,
+		minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get %v: %w", key, err)
+	}
+	// GetObject doesn't contact the server until the object is first used,
+	// so stat it to find out whether it exists.
+	_, err = obj.Stat()
+	if err != nil {
+		obj.Close()
+		if isS3NotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("get %v: %w", key, err)
+	}
+
+	return obj, nil
+}
+
+func (ss *S3Storage) Delete(key string) error {
+	err := ss.client.RemoveObject(context.Background(), ss.bucket, key,
+		minio.RemoveObjectOptions{})
+	if err != nil && !isS3NotFound(err) {
+		return fmt.Errorf("delete %v: %w", key, err)
+	}
+
+	return nil
+}
+
+func (ss *S3Storage) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for obj := range ss.client.ListObjects(context.Background(), ss.bucket,
+		minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("list %v: %w", prefix, obj.Err)
+		}
+		objects = append(objects, ObjectInfo{
+			Key:     obj.Key,
+			Size:    obj.Size,
+			ModTime: obj.LastModified,
+		})
+	}
+
+	return objects, nil
+}
+
+func (ss *S3Storage) Stat(key string) (ObjectInfo, error) {
+	info, err := ss.client.StatObject(context.Background(), ss.bucket, key,
+		minio.StatObjectOptions{})
+	if err != nil {
+		if isS3NotFound(err) {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("stat %v: %w", key, err)
+	}
+
+	return ObjectInfo{
+		Key:     info.Key,
+		Size:    info.Size,
+		ModTime: info.LastModified,
+	}, nil
+}
+
+func isS3NotFound(err error) bool {
+	return minio.ToErrorResponse(err).StatusCode == http.StatusNotFound
+}
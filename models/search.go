@@ -0,0 +1,154 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultSearchResultsPerPage is the number of galleries or images
+	// returned by SearchService when ListOptions.PerPage is not set.
+	DefaultSearchResultsPerPage = 24
+	// MaxSearchQueryLength is the maximum number of characters of a search
+	// query that are used. The rest is ignored.
+	MaxSearchQueryLength = 200
+)
+
+// searchQuery turns the query in $2 into a tsquery named query. Titles,
+// descriptions and captions are indexed as English, so words match in any
+// form, but filenames are indexed as they are.
+const searchQuery = `(
+	SELECT websearch_to_tsquery('english', $2) || websearch_to_tsquery('simple', $2) AS query
+) AS q`
+
+// SearchService finds galleries and images with Postgres full-text search.
+// Users find their own galleries and images, and those in public galleries.
+// Unlisted and password-protected galleries of other users are never
+// returned, as knowing they exist requires their link.
+type SearchService struct {
+	DB *sql.DB
+}
+
+// Galleries returns the galleries matching the query that the user may see,
+// best match first. Titles weigh more than descriptions. A userID of 0
+// searches public galleries only.
+func (s *SearchService) Galleries(userID int, query string, opts ListOptions) ([]Gallery, error) {
+	query = cleanSearchQuery(query)
+	galleries := make([]Gallery, 0)
+	if query == "" {
+		return galleries, nil
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT id, user_id, title, description, visibility, metadata_policy,
+			COALESCE((
+				SELECT id FROM images
+				WHERE images.gallery_id = galleries.id AND images.deleted_at IS NULL
+				ORDER BY images.id = galleries.cover_image_id DESC, position, id
+				LIMIT 1), 0),
+			COALESCE((SELECT string_agg(tag, ',' ORDER BY tag)
+				FROM gallery_tags
+				WHERE gallery_tags.gallery_id = galleries.id), '')
+		FROM galleries, `+searchQuery+`
+		WHERE (user_id = $1 OR visibility = $3) AND deleted_at IS NULL
+			AND search_vector @@ q.query
+		ORDER BY ts_rank(search_vector, q.query) DESC, id DESC
+		LIMIT $4 OFFSET $5;`, userID, query, VisibilityPublic,
+		opts.limit(DefaultSearchResultsPerPage), opts.offset(DefaultSearchResultsPerPage))
+	if err != nil {
+		return nil, fmt.Errorf("search galleries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var gallery Gallery
+		var tags string
+		err = rows.Scan(&gallery.ID, &gallery.UserID, &gallery.Title,
+			&gallery.Description, &gallery.Visibility, &gallery.MetadataPolicy,
+			&gallery.CoverImageID, &tags)
+		if err != nil {
+			return nil, fmt.Errorf("search galleries: %w", err)
+		}
+		gallery.Tags = splitTags(tags)
+		galleries = append(galleries, gallery)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("search galleries: %w", err)
+	}
+
+	return galleries, nil
+}
+
+// Images returns the images matching the query that the user may see, best
+// match first. Titles weigh more than captions, and captions more than
+// filenames. A userID of 0 searches images in public galleries only.
+func (s *SearchService) Images(userID int, query string, opts ListOptions) ([]Image, error) {
+	query = cleanSearchQuery(query)
+	images := make([]Image, 0)
+	if query == "" {
+		return images, nil
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT images.id, images.gallery_id, images.user_id, filename,
+			original_filename, content_type, size, created_at,
+			images.captured_at, images.camera, images.lens, images.exposure_time,
+			images.f_number, images.iso, images.focal_length, images.original_kept,
+			position, images.title, caption, alt_text,
+			COALESCE((SELECT string_agg(tag, ',' ORDER BY tag)
+				FROM image_tags
+				WHERE image_tags.image_id = images.id), '')
+		FROM images
+			JOIN galleries ON galleries.id = images.gallery_id,
+			`+searchQuery+`
+		WHERE (galleries.user_id = $1 OR galleries.visibility = $3)
+			AND images.deleted_at IS NULL AND galleries.deleted_at IS NULL
+			AND images.search_vector @@ q.query
+		ORDER BY ts_rank(images.search_vector, q.query) DESC, images.id DESC
+		LIMIT $4 OFFSET $5;`, userID, query, VisibilityPublic,
+		opts.limit(DefaultSearchResultsPerPage), opts.offset(DefaultSearchResultsPerPage))
+	if err != nil {
+		return nil, fmt.Errorf("search images: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var image Image
+		md := &image.Metadata
+		var originalKept bool
+		var tags string
+		err = rows.Scan(&image.ID, &image.GalleryID, &image.UserID,
+			&image.Filename, &image.OriginalFilename, &image.ContentType, &image.Size, &image.CreatedAt,
+			&md.CapturedAt, &md.Camera, &md.Lens, &md.ExposureTime, &md.FNumber, &md.ISO,
+			&md.FocalLength, &originalKept, &image.Position, &image.Title,
+			&image.Caption, &image.AltText, &tags)
+		if err != nil {
+			return nil, fmt.Errorf("search images: %w", err)
+		}
+		image.Key = imageKey(image.GalleryID, image.Filename)
+		if originalKept {
+			image.OriginalKey = originalKey(image.GalleryID, image.Filename)
+		}
+		image.Tags = splitTags(tags)
+		images = append(images, image)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("search images: %w", err)
+	}
+
+	return images, nil
+}
+
+// cleanSearchQuery trims the query and cuts it to MaxSearchQueryLength
+// characters.
+func cleanSearchQuery(query string) string {
+	query = strings.TrimSpace(query)
+	runes := []rune(query)
+	if len(runes) > MaxSearchQueryLength {
+		query = string(runes[:MaxSearchQueryLength])
+	}
+	return query
+}
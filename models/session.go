@@ -2,7 +2,23 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
+)
+
+const (
+	DefaultSessionLifetime         = 30 * 24 * time.Hour
+	DefaultSessionIdleTimeout      = 7 * 24 * time.Hour
+	DefaultSessionRotationInterval = 1 * time.Hour
+
+	// lastSeenResolution is how often a session's LastSeenAt is updated
+	// while it is being used.
+	lastSeenResolution = 1 * time.Minute
+	// rotationGracePeriod is how long the previous token of a session keeps
+	// working after it was rotated, so that requests that were already in
+	// flight with the old token don't sign the user out.
+	rotationGracePeriod = 1 * time.Minute
 )
 
 type Session struct {
@@ -12,19 +28,37 @@ type Session struct {
 	// When looking up a session this will be left empty,
 	// as we only store the hash of a session token
 	// in our database and we cannot reverse it into a raw token.
-	Token     string
-	TokenHash string
+	Token      string
+	TokenHash  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	// ExpiresAt is when the session expires regardless of activity.
+	ExpiresAt time.Time
+	// UserAgent and IPAddress describe the device the session was
+	// created from.
+	UserAgent string
+	IPAddress string
 }
 
 type SessionService struct {
 	DB           *sql.DB
 	TokenManager TokenManager
+	// Lifetime is the maximum age of a session. Defaults to
+	// DefaultSessionLifetime.
+	Lifetime time.Duration
+	// IdleTimeout is how long a session may go unused before it expires.
+	// Defaults to DefaultSessionIdleTimeout.
+	IdleTimeout time.Duration
+	// RotationInterval is how often the token of a session in use is
+	// replaced with a new one. Defaults to DefaultSessionRotationInterval.
+	RotationInterval time.Duration
 }
 
 // Create will create a new session for the user provided. The session token
 // will be returned as the Token field on the Session type, but only the hashed
-// session token is stored in the database.
-func (ss *SessionService) Create(userID int) (*Session, error) {
+// session token is stored in the database. A user may have many sessions,
+// one for each device they are signed in on.
+func (ss *SessionService) Create(userID int, userAgent, ipAddress string) (*Session, error) {
 	token, tokenHash, err := ss.TokenManager.New()
 	if err != nil {
 		return nil, fmt.Errorf("create session: %w", err)
@@ -34,47 +68,219 @@ func (ss *SessionService) Create(userID int) (*Session, error) {
 		UserID:    userID,
 		Token:     token,
 		TokenHash: tokenHash,
+		UserAgent: userAgent,
+		IPAddress: ipAddress,
 	}
 
 	row := ss.DB.QueryRow(`
-		INSERT INTO sessions(user_id, token_hash)
-		VALUES ($1, $2) ON CONFLICT (user_id) DO
-		UPDATE 
-		SET token_hash = $2
-		RETURNING id;`, session.UserID, session.TokenHash)
-	err = row.Scan(&session.ID)
+		INSERT INTO sessions(user_id, token_hash, user_agent, ip_address)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, last_seen_at;`, session.UserID,
+		session.TokenHash, session.UserAgent, session.IPAddress)
+	err = row.Scan(&session.ID, &session.CreatedAt, &session.LastSeenAt)
 	if err != nil {
 		return nil, fmt.Errorf("create session: %w", err)
 	}
+	session.ExpiresAt = session.CreatedAt.Add(ss.lifetime())
 
 	return &session, nil
 }
 
+// User returns the user the session token belongs to and records that the
+// session has been used. Tokens of expired sessions are rejected.
 func (ss *SessionService) User(token string) (*User, error) {
 	tokenHash := ss.TokenManager.Hash(token)
 	var user User
+	var sessionID int
+	var lastSeenAt time.Time
 	row := ss.DB.QueryRow(`
-		SELECT users.id, users.email, users.password_hash 
+		SELECT sessions.id, sessions.last_seen_at,
+			users.id, users.email, users.password_hash,
+			users.email_verified_at IS NOT NULL, users.totp_enabled_at IS NOT NULL
 		FROM sessions
 		JOIN users ON users.id = sessions.user_id
-		WHERE sessions.token_hash = $1;`, tokenHash)
-	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash)
+		WHERE (sessions.token_hash = $1
+			OR (sessions.previous_token_hash = $1
+				AND sessions.rotated_at > now() - $2::float8 * interval '1 second'))
+			AND sessions.created_at > now() - $3::float8 * interval '1 second'
+			AND sessions.last_seen_at > now() - $4::float8 * interval '1 second';`,
+		tokenHash, rotationGracePeriod.Seconds(), ss.lifetime().Seconds(),
+		ss.idleTimeout().Seconds())
+	err := row.Scan(&sessionID, &lastSeenAt, &user.ID, &user.Email,
+		&user.PasswordHash, &user.EmailVerified, &user.TwoFactorEnabled)
 	if err != nil {
 		return nil, fmt.Errorf("user session: %w", err)
 	}
 
+	if time.Since(lastSeenAt) > lastSeenResolution {
+		_, err = ss.DB.Exec(`
+			UPDATE sessions
+			SET last_seen_at = now()
+			WHERE id = $1;`, sessionID)
+		if err != nil {
+			return nil, fmt.Errorf("user session: %w", err)
+		}
+	}
+
 	return &user, nil
 }
 
+// Rotate replaces the token of the session if it hasn't been replaced for
+// longer than the RotationInterval. The returned Session has the new Token
+// set. If the token does not need to be rotated yet, Rotate returns nil.
+func (ss *SessionService) Rotate(token string) (*Session, error) {
+	newToken, newTokenHash, err := ss.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("rotate session: %w", err)
+	}
+
+	session := Session{
+		Token:     newToken,
+		TokenHash: newTokenHash,
+	}
+	row := ss.DB.QueryRow(`
+		UPDATE sessions
+		SET previous_token_hash = token_hash, token_hash = $2, rotated_at = now()
+		WHERE token_hash = $1
+			AND rotated_at <= now() - $3::float8 * interval '1 second'
+		RETURNING id, user_id, created_at, last_seen_at, user_agent, ip_address;`,
+		ss.TokenManager.Hash(token), session.TokenHash, ss.rotationInterval().Seconds())
+	err = row.Scan(&session.ID, &session.UserID, &session.CreatedAt,
+		&session.LastSeenAt, &session.UserAgent, &session.IPAddress)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("rotate session: %w", err)
+	}
+	session.ExpiresAt = session.CreatedAt.Add(ss.lifetime())
+
+	return &session, nil
+}
+
+// ByUserID returns all of the user's sessions, most recently used first.
+func (ss *SessionService) ByUserID(userID int) ([]Session, error) {
+	rows, err := ss.DB.Query(`
+		SELECT id, token_hash, created_at, last_seen_at, user_agent, ip_address
+		FROM sessions
+		WHERE user_id = $1
+			AND created_at > now() - $2::float8 * interval '1 second'
+			AND last_seen_at > now() - $3::float8 * interval '1 second'
+		ORDER BY last_seen_at DESC;`, userID, ss.lifetime().Seconds(),
+		ss.idleTimeout().Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("query sessions by user id: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session := Session{
+			UserID: userID,
+		}
+		err = rows.Scan(&session.ID, &session.TokenHash, &session.CreatedAt,
+			&session.LastSeenAt, &session.UserAgent, &session.IPAddress)
+		if err != nil {
+			return nil, fmt.Errorf("query sessions by user id: %w", err)
+		}
+		session.ExpiresAt = session.CreatedAt.Add(ss.lifetime())
+		sessions = append(sessions, session)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("query sessions by user id: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// Hash returns the hash of a session token, which can be compared with the
+// TokenHash of a Session.
+func (ss *SessionService) Hash(token string) string {
+	return ss.TokenManager.Hash(token)
+}
+
 func (ss *SessionService) Delete(token string) error {
 	tokenHash := ss.TokenManager.Hash(token)
 	_, err := ss.DB.Exec(`
 		DELETE
-		FROM sessions 
-		WHERE token_hash = $1;`, tokenHash)
+		FROM sessions
+		WHERE token_hash = $1 OR previous_token_hash = $1;`, tokenHash)
 	if err != nil {
 		return fmt.Errorf("delete session: %w", err)
 	}
 
 	return nil
 }
+
+// DeleteByID deletes one of the user's sessions, signing out the device it
+// belongs to. It returns ErrNotFound if the user has no such session.
+func (ss *SessionService) DeleteByID(userID, id int) error {
+	res, err := ss.DB.Exec(`
+		DELETE
+		FROM sessions
+		WHERE id = $1 AND user_id = $2;`, id, userID)
+	if err != nil {
+		return fmt.Errorf("delete session by id: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete session by id: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteByUserID deletes all of the user's sessions, signing them out
+// everywhere.
+func (ss *SessionService) DeleteByUserID(userID int) error {
+	_, err := ss.DB.Exec(`
+		DELETE
+		FROM sessions
+		WHERE user_id = $1;`, userID)
+	if err != nil {
+		return fmt.Errorf("delete sessions by user id: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpired deletes every session that has outlived its lifetime or has
+// been idle for too long.
+func (ss *SessionService) DeleteExpired() error {
+	_, err := ss.DB.Exec(`
+		DELETE
+		FROM sessions
+		WHERE created_at <= now() - $1::float8 * interval '1 second'
+			OR last_seen_at <= now() - $2::float8 * interval '1 second';`,
+		ss.lifetime().Seconds(), ss.idleTimeout().Seconds())
+	if err != nil {
+		return fmt.Errorf("delete expired sessions: %w", err)
+	}
+
+	return nil
+}
+
+func (ss *SessionService) lifetime() time.Duration {
+	if ss.Lifetime <= 0 {
+		return DefaultSessionLifetime
+	}
+	return ss.Lifetime
+}
+
+func (ss *SessionService) idleTimeout() time.Duration {
+	if ss.IdleTimeout <= 0 {
+		return DefaultSessionIdleTimeout
+	}
+	return ss.IdleTimeout
+}
+
+func (ss *SessionService) rotationInterval() time.Duration {
+	if ss.RotationInterval <= 0 {
+		return DefaultSessionRotationInterval
+	}
+	return ss.RotationInterval
+}
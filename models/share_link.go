@@ -0,0 +1,165 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ShareLink gives anyone who has its token access to a gallery until it
+// expires or is revoked, regardless of the gallery's visibility.
+type ShareLink struct {
+	ID        int
+	GalleryID int
+	// Token is only set when a ShareLink is being created.
+	Token     string
+	TokenHash string
+	// AllowDownload lets visitors download the original image files instead
+	// of only viewing resized copies.
+	AllowDownload bool
+	ViewCount     int
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	// RevokedAt is nil unless the link has been revoked.
+	RevokedAt *time.Time
+}
+
+// Active reports whether the link can still be used.
+func (l *ShareLink) Active() bool {
+	return l.RevokedAt == nil && time.Now().Before(l.ExpiresAt)
+}
+
+type ShareLinkService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+}
+
+// Create creates a share link for the gallery that is valid until expiresAt.
+func (s *ShareLinkService) Create(galleryID int, expiresAt time.Time, allowDownload bool) (*ShareLink, error) {
+	if !expiresAt.After(time.Now()) {
+		return nil, ErrTokenExpired
+	}
+
+	token, tokenHash, err := s.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("create share link: %w", err)
+	}
+	link := ShareLink{
+		GalleryID:     galleryID,
+		Token:         token,
+		TokenHash:     tokenHash,
+		AllowDownload: allowDownload,
+		ExpiresAt:     expiresAt,
+	}
+
+	row := s.DB.QueryRow(`
+		INSERT INTO share_links (gallery_id, token_hash, allow_download, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at;`, link.GalleryID, link.TokenHash,
+		link.AllowDownload, link.ExpiresAt)
+	err = row.Scan(&link.ID, &link.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create share link: %w", err)
+	}
+
+	return &link, nil
+}
+
+// ByToken returns the share link for the token. It returns ErrNotFound if
+// there is no such link or it has been revoked, and ErrTokenExpired if it
+// has expired.
+func (s *ShareLinkService) ByToken(token string) (*ShareLink, error) {
+	link := ShareLink{
+		TokenHash: s.TokenManager.Hash(token),
+	}
+	row := s.DB.QueryRow(`
+		SELECT id, gallery_id, allow_download, view_count, created_at,
+			expires_at, revoked_at
+		FROM share_links
+		WHERE token_hash = $1;`, link.TokenHash)
+	err := row.Scan(&link.ID, &link.GalleryID, &link.AllowDownload,
+		&link.ViewCount, &link.CreatedAt, &link.ExpiresAt, &link.RevokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query share link by token: %w", err)
+	}
+	if link.RevokedAt != nil {
+		return nil, ErrNotFound
+	}
+	if time.Now().After(link.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	return &link, nil
+}
+
+// ByGalleryID returns all share links of the gallery, including expired and
+// revoked ones, newest first.
+func (s *ShareLinkService) ByGalleryID(galleryID int) ([]ShareLink, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, token_hash, allow_download, view_count, created_at,
+			expires_at, revoked_at
+		FROM share_links
+		WHERE gallery_id = $1
+		ORDER BY created_at DESC, id DESC;`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("query share links by gallery id: %w", err)
+	}
+	defer rows.Close()
+
+	var links []ShareLink
+	for rows.Next() {
+		link := ShareLink{
+			GalleryID: galleryID,
+		}
+		err = rows.Scan(&link.ID, &link.TokenHash, &link.AllowDownload,
+			&link.ViewCount, &link.CreatedAt, &link.ExpiresAt, &link.RevokedAt)
+		if err != nil {
+			return nil, fmt.Errorf("query share links by gallery id: %w", err)
+		}
+		links = append(links, link)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("query share links by gallery id: %w", err)
+	}
+
+	return links, nil
+}
+
+// RecordView increments the view count of the share link.
+func (s *ShareLinkService) RecordView(id int) error {
+	_, err := s.DB.Exec(`
+		UPDATE share_links
+		SET view_count = view_count + 1
+		WHERE id = $1;`, id)
+	if err != nil {
+		return fmt.Errorf("record share link view: %w", err)
+	}
+
+	return nil
+}
+
+// Revoke stops one of the gallery's share links from working. It returns
+// ErrNotFound if the gallery has no such link.
+func (s *ShareLinkService) Revoke(galleryID, id int) error {
+	res, err := s.DB.Exec(`
+		UPDATE share_links
+		SET revoked_at = COALESCE(revoked_at, now())
+		WHERE id = $1 AND gallery_id = $2;`, id, galleryID)
+	if err != nil {
+		return fmt.Errorf("revoke share link: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("revoke share link: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ObjectInfo describes an object held by a Storage backend.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage is implemented by the backends that hold image files. Keys are
+// slash-separated relative paths such as "gallery-1/photo.jpg".
+type Storage interface {
+	// Put stores the contents of r under key, replacing any existing object.
+	// size is the number of bytes in r, or -1 if it is not known.
+	Put(key string, r io.Reader, size int64) error
+	// Get opens the object stored under key. It returns ErrNotFound if there
+	// is no such object. Callers must close the returned object.
+	Get(key string) (io.ReadSeekCloser, error)
+	// Delete removes the object stored under key. Deleting an object that
+	// does not exist is not an error.
+	Delete(key string) error
+	// List returns every object whose key starts with prefix, sorted by key.
+	List(prefix string) ([]ObjectInfo, error)
+	// Stat returns information about the object stored under key. It returns
+	// ErrNotFound if there is no such object.
+	Stat(key string) (ObjectInfo, error)
+}
+
+// DefaultStorage is the storage used by services that don't have one
+// configured.
+var DefaultStorage Storage = &LocalStorage{}
+
+// tempFilePrefix starts the names of the files LocalStorage writes before
+// renaming them into place. Files left behind by a crash are reported by
+// ImageService.FindOrphans.
+const tempFilePrefix = ".put-"
+
+// LocalStorage stores objects as files on the local disk.
+type LocalStorage struct {
+	// Dir is the directory objects are stored in. If not set, LocalStorage
+	// will default to using the "images" directory.
+	Dir string
+}
+
+func (ls *LocalStorage) Put(key string, r io.Reader, size int64) error {
+	name, err := ls.path(key)
+	if err != nil {
+		return fmt.Errorf("put %v: %w", key, err)
+	}
+	err = os.MkdirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		return fmt.Errorf("put %v: %w", key, err)
+	}
+
+	// The contents are written to a temporary file that is renamed into
+	// place, so readers never see a partially written file and a failed write
+	// leaves the previous object, if any, as it was.
+	tmp, err := os.CreateTemp(filepath.Dir(name), tempFilePrefix+"*")
+	if err != nil {
+		return fmt.Errorf("put %v: %w", key, err)
+	}
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("put %v: %w", key, err)
+	}
+
+	return nil
+}
+
+func (ls *LocalStorage) Get(key string) (io.ReadSeekCloser, error) {
+	name, err := ls.path(key)
+	if err != nil {
+		return nil, fmt.Errorf("get %v: %w", key, err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("get %v: %w", key, err)
+	}
+
+	return f, nil
+}
+
+func (ls *LocalStorage) Delete(key string) error {
+	name, err := ls.path(key)
+	if err != nil {
+		return fmt.Errorf("delete %v: %w", key, err)
+	}
+
+	err = os.Remove(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete %v: %w", key, err)
+	}
+	ls.removeEmptyDirs(filepath.Dir(name))
+
+	return nil
+}
+
+func (ls *LocalStorage) List(prefix string) ([]ObjectInfo, error) {
+	root := ls.dir()
+	// Only the directory that holds every key with the prefix is walked. If
+	// it doesn't exist, no keys have the prefix.
+	start := root
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		dir, err := ls.path(prefix[:i])
+		if err != nil {
+			return nil, fmt.Errorf("list %v: %w", prefix, err)
+		}
+		start = dir
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(start, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:     key,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %v: %w", prefix, err)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	return objects, nil
+}
+
+func (ls *LocalStorage) Stat(key string) (ObjectInfo, error) {
+	name, err := ls.path(key)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat %v: %w", key, err)
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("stat %v: %w", key, err)
+	}
+	if !info.Mode().IsRegular() {
+		return ObjectInfo{}, ErrNotFound
+	}
+
+	return ObjectInfo{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+func (ls *LocalStorage) dir() string {
+	if ls.Dir == "" {
+		return "images"
+	}
+	return ls.Dir
+}
+
+// path converts a key into a file path inside the storage directory. Keys
+// that would escape the directory are rejected.
+func (ls *LocalStorage) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned[1:] != key {
+		return "", fmt.Errorf("invalid key")
+	}
+
+	return filepath.Join(ls.dir(), filepath.FromSlash(key)), nil
+}
+
+// removeEmptyDirs removes dir and its parents until it reaches a directory
+// that is not empty or the storage directory itself.
+func (ls *LocalStorage) removeEmptyDirs(dir string) {
+	root := filepath.Clean(ls.dir())
+	for dir != root && strings.HasPrefix(dir, root) {
+		// os.Remove refuses to delete directories that aren't empty.
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// galleryPrefix returns the key prefix under which a gallery's images are
+// stored.
+func galleryPrefix(galleryID int) string {
+	return fmt.Sprintf("gallery-%d/", galleryID)
+}
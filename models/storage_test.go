@@ -0,0 +1,46 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocalStorageList(t *testing.T) {
+	ls := &LocalStorage{Dir: t.TempDir()}
+	for _, key := range []string{
+		"gallery-1/a.jpg",
+		"gallery-1/originals/a.jpg",
+		"gallery-10/b.jpg",
+		"gallery-2/c.jpg",
+	} {
+		err := ls.Put(key, strings.NewReader(key), int64(len(key)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string][]string{
+		"":                      {"gallery-1/a.jpg", "gallery-1/originals/a.jpg", "gallery-10/b.jpg", "gallery-2/c.jpg"},
+		"gallery-1":             {"gallery-1/a.jpg", "gallery-1/originals/a.jpg", "gallery-10/b.jpg"},
+		"gallery-1/":            {"gallery-1/a.jpg", "gallery-1/originals/a.jpg"},
+		"gallery-1/originals/":  {"gallery-1/originals/a.jpg"},
+		"gallery-2/c":           {"gallery-2/c.jpg"},
+		"gallery-3/":            nil,
+		"gallery-3/originals/x": nil,
+	}
+	for prefix, want := range tests {
+		t.Run(prefix, func(t *testing.T) {
+			objects, err := ls.List(prefix)
+			if err != nil {
+				t.Fatalf("List(%q) err = %v", prefix, err)
+			}
+			var got []string
+			for _, o := range objects {
+				got = append(got, o.Key)
+			}
+			if strings.Join(got, " ") != strings.Join(want, " ") {
+				t.Errorf("List(%q) = %v, want %v", prefix, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTagLength is the maximum number of characters allowed in a tag.
+	MaxTagLength = 50
+	// MaxTags is the maximum number of tags of a gallery or an image.
+	MaxTags = 20
+	// DefaultTaggedImagesPerPage is the number of images returned by
+	// ImageService.ByTag when ListOptions.PerPage is not set.
+	DefaultTaggedImagesPerPage = 48
+)
+
+// ParseTags splits a comma separated list of tags, as they are entered in
+// forms.
+func ParseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// normalizeTag lowercases the tag and collapses its whitespace, so "Wedding"
+// and " wedding " are the same tag.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), " "))
+}
+
+// normalizeTags normalizes the tags, drops empty ones and duplicates, and
+// checks that the rest are valid. Tags may only contain letters, digits,
+// spaces, hyphens and underscores, which keeps them usable in URLs.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > MaxTagLength {
+			return nil, ErrInvalidTag
+		}
+		for _, r := range tag {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' && r != '_' {
+				return nil, ErrInvalidTag
+			}
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > MaxTags {
+		return nil, ErrTooManyTags
+	}
+
+	return normalized, nil
+}
+
+// splitTags splits the tags aggregated by string_agg in queries. Tags can't
+// contain commas, so they are used as the separator.
+func splitTags(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// saveTags replaces the tags of the row with the given id in table, which is
+// gallery_tags or image_tags.
+func saveTags(tx *sql.Tx, table, column string, id int, tags []string) error {
+	_, err := tx.Exec(`
+		DELETE FROM `+table+`
+		WHERE `+column+` = $1;`, id)
+	if err != nil {
+		return fmt.Errorf("save tags: %w", err)
+	}
+	for _, tag := range tags {
+		_, err = tx.Exec(`
+			INSERT INTO `+table+` (`+column+`, tag)
+			VALUES ($1, $2);`, id, tag)
+		if err != nil {
+			return fmt.Errorf("save tags: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ByTag returns a page of the images with the tag in the user's galleries,
+// newest first.
+func (s *ImageService) ByTag(userID int, tag string, opts ListOptions) ([]Image, error) {
+	rows, err := s.DB.Query(`
+		SELECT images.id, images.gallery_id, images.user_id, filename,
+			original_filename, content_type, size, created_at, original_kept, position,
+			images.title, caption, alt_text,
+			COALESCE((SELECT string_agg(tag, ',' ORDER BY tag)
+				FROM image_tags
+				WHERE image_tags.image_id = images.id), '')
+		FROM images
+			JOIN galleries ON galleries.id = images.gallery_id
+			JOIN image_tags ON image_tags.image_id = images.id
+		WHERE galleries.user_id = $1 AND image_tags.tag = $2
+			AND images.deleted_at IS NULL AND galleries.deleted_at IS NULL
+		ORDER BY images.created_at DESC, images.id DESC
+		LIMIT $3 OFFSET $4;`, userID, normalizeTag(tag),
+		opts.limit(DefaultTaggedImagesPerPage), opts.offset(DefaultTaggedImagesPerPage))
+	if err != nil {
+		return nil, fmt.Errorf("query images by tag: %w", err)
+	}
+	defer rows.Close()
+
+	var images []Image
+	for rows.Next() {
+		var image Image
+		var originalKept bool
+		var tags string
+		err = rows.Scan(&image.ID, &image.GalleryID, &image.UserID,
+			&image.Filename, &image.OriginalFilename, &image.ContentType, &image.Size, &image.CreatedAt,
+			&originalKept, &image.Position, &image.Title, &image.Caption,
+			&image.AltText, &tags)
+		if err != nil {
+			return nil, fmt.Errorf("query images by tag: %w", err)
+		}
+		image.Key = imageKey(image.GalleryID, image.Filename)
+		if originalKept {
+			image.OriginalKey = originalKey(image.GalleryID, image.Filename)
+		}
+		image.Tags = splitTags(tags)
+		images = append(images, image)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("query images by tag: %w", err)
+	}
+
+	return images, nil
+}
+
+// CountByTag returns the number of images with the tag in the user's
+// galleries.
+func (s *ImageService) CountByTag(userID int, tag string) (int, error) {
+	var count int
+	row := s.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM image_tags
+			JOIN images ON images.id = image_tags.image_id
+			JOIN galleries ON galleries.id = images.gallery_id
+		WHERE galleries.user_id = $1 AND image_tags.tag = $2
+			AND images.deleted_at IS NULL AND galleries.deleted_at IS NULL;`, userID, normalizeTag(tag))
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count images by tag: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,235 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DefaultTrashRetention is how long deleted galleries and images are kept
+// when TrashService.Retention is not set.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// TrashService lists and restores the galleries and images users deleted,
+// and purges them for good once they have been in the trash for longer than
+// the retention period.
+type TrashService struct {
+	DB *sql.DB
+
+	GalleryService *GalleryService
+	ImageService   *ImageService
+	// Retention is how long deleted galleries and images are kept. Defaults
+	// to DefaultTrashRetention.
+	Retention time.Duration
+}
+
+// TrashedGallery is a gallery in the trash.
+type TrashedGallery struct {
+	Gallery
+	DeletedAt time.Time
+}
+
+// TrashedImage is an image in the trash. Images of galleries in the trash
+// are restored with their gallery, so they aren't listed on their own.
+type TrashedImage struct {
+	Image
+	GalleryTitle string
+	DeletedAt    time.Time
+}
+
+// Galleries returns the user's galleries in the trash, most recently deleted
+// first.
+func (s *TrashService) Galleries(userID int) ([]TrashedGallery, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, title, deleted_at
+		FROM galleries
+		WHERE user_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query trashed galleries: %w", err)
+	}
+	defer rows.Close()
+
+	var galleries []TrashedGallery
+	for rows.Next() {
+		gallery := TrashedGallery{
+			Gallery: Gallery{
+				UserID: userID,
+			},
+		}
+		err = rows.Scan(&gallery.ID, &gallery.Title, &gallery.DeletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("query trashed galleries: %w", err)
+		}
+		galleries = append(galleries, gallery)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("query trashed galleries: %w", err)
+	}
+
+	return galleries, nil
+}
+
+// Images returns the images in the trash from the user's galleries that
+// aren't in the trash themselves, most recently deleted first.
+func (s *TrashService) Images(userID int) ([]TrashedImage, error) {
+	rows, err := s.DB.Query(`
+		SELECT images.id, images.gallery_id, images.user_id, filename,
+			original_filename, images.title, galleries.title, images.deleted_at
+		FROM images
+			JOIN galleries ON galleries.id = images.gallery_id
+		WHERE galleries.user_id = $1 AND galleries.deleted_at IS NULL
+			AND images.deleted_at IS NOT NULL
+		ORDER BY images.deleted_at DESC, images.id DESC;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query trashed images: %w", err)
+	}
+	defer rows.Close()
+
+	var images []TrashedImage
+	for rows.Next() {
+		var image TrashedImage
+		err = rows.Scan(&image.ID, &image.GalleryID, &image.UserID,
+			&image.Filename, &image.OriginalFilename, &image.Title, &image.GalleryTitle, &image.DeletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("query trashed images: %w", err)
+		}
+		image.Key = imageKey(image.GalleryID, image.Filename)
+		images = append(images, image)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("query trashed images: %w", err)
+	}
+
+	return images, nil
+}
+
+// RestoreGallery takes the user's gallery out of the trash. ErrNotFound is
+// returned if the user has no such gallery in the trash.
+func (s *TrashService) RestoreGallery(userID, id int) error {
+	res, err := s.DB.Exec(`
+		UPDATE galleries
+		SET deleted_at = NULL
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL;`, id, userID)
+	if err != nil {
+		return fmt.Errorf("restore gallery: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("restore gallery: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// RestoreImage takes the image out of the trash, back to its place in the
+// gallery. ErrNotFound is returned if the image isn't in the trash, or its
+// gallery doesn't belong to the user or is in the trash itself.
+func (s *TrashService) RestoreImage(userID, id int) error {
+	res, err := s.DB.Exec(`
+		UPDATE images
+		SET deleted_at = NULL
+		FROM galleries
+		WHERE images.id = $1 AND images.deleted_at IS NOT NULL
+			AND galleries.id = images.gallery_id AND galleries.user_id = $2
+			AND galleries.deleted_at IS NULL;`, id, userID)
+	if err != nil {
+		return fmt.Errorf("restore image: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("restore image: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// PurgeAt returns when an item deleted at deletedAt will be purged.
+func (s *TrashService) PurgeAt(deletedAt time.Time) time.Time {
+	return deletedAt.Add(s.retention())
+}
+
+// Purge removes the galleries and images that have been in the trash for
+// longer than the retention period, along with their files. It is meant to
+// be called periodically.
+func (s *TrashService) Purge() error {
+	deletedBefore := time.Now().Add(-s.retention())
+
+	var galleryIDs []int
+	rows, err := s.DB.Query(`
+		SELECT id
+		FROM galleries
+		WHERE deleted_at < $1;`, deletedBefore)
+	if err != nil {
+		return fmt.Errorf("purge trash: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return fmt.Errorf("purge trash: %w", err)
+		}
+		galleryIDs = append(galleryIDs, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("purge trash: %w", err)
+	}
+	for _, id := range galleryIDs {
+		err = s.GalleryService.purge(id, deletedBefore)
+		if err != nil {
+			return fmt.Errorf("purge trash: %w", err)
+		}
+	}
+
+	var images []Image
+	rows, err = s.DB.Query(`
+		SELECT id, gallery_id, filename, original_kept
+		FROM images
+		WHERE deleted_at < $1;`, deletedBefore)
+	if err != nil {
+		return fmt.Errorf("purge trash: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var image Image
+		var originalKept bool
+		err = rows.Scan(&image.ID, &image.GalleryID, &image.Filename, &originalKept)
+		if err != nil {
+			return fmt.Errorf("purge trash: %w", err)
+		}
+		image.Key = imageKey(image.GalleryID, image.Filename)
+		if originalKept {
+			image.OriginalKey = originalKey(image.GalleryID, image.Filename)
+		}
+		images = append(images, image)
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("purge trash: %w", err)
+	}
+	for i := range images {
+		err = s.ImageService.purge(&images[i], deletedBefore)
+		if err != nil {
+			return fmt.Errorf("purge trash: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *TrashService) retention() time.Duration {
+	if s.Retention <= 0 {
+		return DefaultTrashRetention
+	}
+	return s.Retention
+}
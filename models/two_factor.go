@@ -0,0 +1,406 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alexproskurov/snapfolio/rand"
+)
+
+const (
+	// DefaultTOTPIssuer is the name authenticator apps show next to codes
+	// for SnapFolio accounts.
+	DefaultTOTPIssuer = "SnapFolio"
+	// DefaultPendingSignInDuration is how long a user has to enter their
+	// second factor after entering their password.
+	DefaultPendingSignInDuration = 10 * time.Minute
+	// MaxTwoFactorAttempts is how many wrong codes may be entered for a
+	// pending sign in before the user has to enter their password again.
+	MaxTwoFactorAttempts = 5
+	// RecoveryCodeCount is the number of recovery codes generated when
+	// two-factor authentication is enabled.
+	RecoveryCodeCount = 10
+)
+
+// TOTPEnrollment holds what a user needs to add their account to an
+// authenticator app.
+type TOTPEnrollment struct {
+	Secret string
+	// URI is the otpauth:// provisioning URI, usually shown as a QR code.
+	URI string
+}
+
+type PendingSignIn struct {
+	ID     int
+	UserID int
+	// Token is only set when a PendingSignIn is being created.
+	Token     string
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+// TwoFactorService manages TOTP two-factor authentication. Signing in to an
+// account with two-factor authentication enabled is done in two steps: once
+// the password has been checked a PendingSignIn is created, and only when the
+// second factor has been verified is a real session created.
+type TwoFactorService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+	// Issuer is shown by authenticator apps. Defaults to DefaultTOTPIssuer.
+	Issuer string
+}
+
+// Enroll generates a new TOTP secret for the user. Two-factor authentication
+// is not enabled until Enable is called with a code generated from the secret.
+func (tfs *TwoFactorService) Enroll(user *User) (*TOTPEnrollment, error) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("enroll totp: %w", err)
+	}
+
+	res, err := tfs.DB.Exec(`
+		UPDATE users
+		SET totp_secret = $2
+		WHERE id = $1 AND totp_enabled_at IS NULL;`, user.ID, secret)
+	if err != nil {
+		return nil, fmt.Errorf("enroll totp: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("enroll totp: %w", err)
+	}
+	if n == 0 {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	return tfs.enrollment(user, secret), nil
+}
+
+// Enrollment returns the enrollment started by Enroll that has not been
+// confirmed with Enable yet. It returns ErrNotFound if there is none.
+func (tfs *TwoFactorService) Enrollment(user *User) (*TOTPEnrollment, error) {
+	var secret sql.NullString
+	row := tfs.DB.QueryRow(`
+		SELECT totp_secret
+		FROM users
+		WHERE id = $1 AND totp_enabled_at IS NULL;`, user.ID)
+	err := row.Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("totp enrollment: %w", err)
+	}
+	if !secret.Valid {
+		return nil, ErrNotFound
+	}
+
+	return tfs.enrollment(user, secret.String), nil
+}
+
+// Enable turns on two-factor authentication once the user has proven that
+// their authenticator app generates valid codes for the enrolled secret. It
+// returns the user's recovery codes, which are only available at this point.
+func (tfs *TwoFactorService) Enable(userID int, code string) ([]string, error) {
+	var secret sql.NullString
+	row := tfs.DB.QueryRow(`
+		SELECT totp_secret
+		FROM users
+		WHERE id = $1 AND totp_enabled_at IS NULL;`, userID)
+	err := row.Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTwoFactorEnabled
+		}
+		return nil, fmt.Errorf("enable totp: %w", err)
+	}
+	if !secret.Valid {
+		return nil, ErrInvalidCode
+	}
+	step, ok := validateTOTP(secret.String, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	tx, err := tfs.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("enable totp: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET totp_enabled_at = now(), totp_last_step = $2
+		WHERE id = $1;`, userID, step)
+	if err != nil {
+		return nil, fmt.Errorf("enable totp: %w", err)
+	}
+	codes, err := tfs.replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("enable totp: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("enable totp: %w", err)
+	}
+
+	return codes, nil
+}
+
+// Disable turns off two-factor authentication and removes the user's secret
+// and recovery codes.
+func (tfs *TwoFactorService) Disable(userID int) error {
+	tx, err := tfs.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0
+		WHERE id = $1;`, userID)
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+	_, err = tx.Exec(`
+		DELETE FROM recovery_codes
+		WHERE user_id = $1;`, userID)
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+
+	return nil
+}
+
+// Enabled reports whether the user has two-factor authentication turned on.
+func (tfs *TwoFactorService) Enabled(userID int) (bool, error) {
+	var enabled bool
+	row := tfs.DB.QueryRow(`
+		SELECT totp_enabled_at IS NOT NULL
+		FROM users
+		WHERE id = $1;`, userID)
+	err := row.Scan(&enabled)
+	if err != nil {
+		return false, fmt.Errorf("totp enabled: %w", err)
+	}
+
+	return enabled, nil
+}
+
+// Verify checks a code entered by the user. Both codes from the
+// authenticator app and unused recovery codes are accepted, but each of them
+// only once. It returns ErrInvalidCode if the code is not valid.
+func (tfs *TwoFactorService) Verify(userID int, code string) error {
+	code = strings.TrimSpace(code)
+
+	var secret string
+	row := tfs.DB.QueryRow(`
+		SELECT totp_secret
+		FROM users
+		WHERE id = $1 AND totp_enabled_at IS NOT NULL;`, userID)
+	err := row.Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidCode
+		}
+		return fmt.Errorf("verify totp: %w", err)
+	}
+
+	step, ok := validateTOTP(secret, code, time.Now())
+	if ok {
+		// Codes may only be used once, so the step is only accepted if it is
+		// newer than the last one used.
+		res, err := tfs.DB.Exec(`
+			UPDATE users
+			SET totp_last_step = $2
+			WHERE id = $1 AND totp_last_step < $2;`, userID, step)
+		if err != nil {
+			return fmt.Errorf("verify totp: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("verify totp: %w", err)
+		}
+		if n == 0 {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	res, err := tfs.DB.Exec(`
+		UPDATE recovery_codes
+		SET used_at = now()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;`,
+		userID, tfs.TokenManager.Hash(normalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("verify recovery code: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("verify recovery code: %w", err)
+	}
+	if n == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// CreatePending records that the user has entered their password but still
+// has to provide their second factor.
+func (tfs *TwoFactorService) CreatePending(userID int) (*PendingSignIn, error) {
+	token, tokenHash, err := tfs.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("create pending sign in: %w", err)
+	}
+	pending := PendingSignIn{
+		UserID:    userID,
+		Token:     token,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(DefaultPendingSignInDuration),
+	}
+
+	row := tfs.DB.QueryRow(`
+		INSERT INTO pending_signins (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id;`, pending.UserID, pending.TokenHash, pending.ExpiresAt)
+	err = row.Scan(&pending.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create pending sign in: %w", err)
+	}
+
+	return &pending, nil
+}
+
+// CompletePending verifies the code for the pending sign in identified by
+// token and returns the ID of the user signing in. Wrong codes return
+// ErrInvalidCode; once too many wrong codes have been entered, or the pending
+// sign in has expired, ErrTokenExpired is returned and the user has to start
+// over.
+func (tfs *TwoFactorService) CompletePending(token, code string) (int, error) {
+	tokenHash := tfs.TokenManager.Hash(token)
+	var pending PendingSignIn
+	var attempts int
+	row := tfs.DB.QueryRow(`
+		UPDATE pending_signins
+		SET attempts = attempts + 1
+		WHERE token_hash = $1
+		RETURNING id, user_id, attempts, expires_at;`, tokenHash)
+	err := row.Scan(&pending.ID, &pending.UserID, &attempts, &pending.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrTokenExpired
+		}
+		return 0, fmt.Errorf("complete pending sign in: %w", err)
+	}
+	if attempts > MaxTwoFactorAttempts || time.Now().After(pending.ExpiresAt) {
+		err = tfs.deletePending(pending.ID)
+		if err != nil {
+			return 0, fmt.Errorf("complete pending sign in: %w", err)
+		}
+		return 0, ErrTokenExpired
+	}
+
+	err = tfs.Verify(pending.UserID, code)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tfs.deletePending(pending.ID)
+	if err != nil {
+		return 0, fmt.Errorf("complete pending sign in: %w", err)
+	}
+
+	return pending.UserID, nil
+}
+
+// DeleteExpired deletes every pending sign in that has expired.
+func (tfs *TwoFactorService) DeleteExpired() error {
+	_, err := tfs.DB.Exec(`
+		DELETE FROM pending_signins
+		WHERE expires_at <= now();`)
+	if err != nil {
+		return fmt.Errorf("delete expired pending sign ins: %w", err)
+	}
+
+	return nil
+}
+
+func (tfs *TwoFactorService) enrollment(user *User, secret string) *TOTPEnrollment {
+	issuer := tfs.Issuer
+	if issuer == "" {
+		issuer = DefaultTOTPIssuer
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totpURI(issuer, user.Email, secret),
+	}
+}
+
+func (tfs *TwoFactorService) deletePending(id int) error {
+	_, err := tfs.DB.Exec(`
+		DELETE FROM pending_signins
+		WHERE id = $1;`, id)
+	if err != nil {
+		return fmt.Errorf("delete pending sign in: %w", err)
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes generates a new set of recovery codes for the user,
+// invalidating any existing ones.
+func (tfs *TwoFactorService) replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	_, err := tx.Exec(`
+		DELETE FROM recovery_codes
+		WHERE user_id = $1;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("replace recovery codes: %w", err)
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("replace recovery codes: %w", err)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO recovery_codes (user_id, code_hash)
+			VALUES ($1, $2);`, userID, tfs.TokenManager.Hash(normalizeRecoveryCode(codes[i])))
+		if err != nil {
+			return nil, fmt.Errorf("replace recovery codes: %w", err)
+		}
+	}
+
+	return codes, nil
+}
+
+// newRecoveryCode returns a random code formatted as two groups of five
+// characters, e.g. "k3j9d-x8w2q".
+func newRecoveryCode() (string, error) {
+	b, err := rand.Bytes(7)
+	if err != nil {
+		return "", err
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode makes recovery codes comparable regardless of how the
+// user typed them.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
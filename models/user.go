@@ -15,6 +15,12 @@ type User struct {
 	ID           int
 	Email        string
 	PasswordHash string
+	// EmailVerified reports whether the user has confirmed that they own
+	// their email address.
+	EmailVerified bool
+	// TwoFactorEnabled reports whether signing in requires a code from the
+	// user's authenticator app.
+	TwoFactorEnabled bool
 }
 
 type UserService struct {
@@ -57,9 +63,11 @@ func (us *UserService) Authenticate(email, password string) (*User, error) {
 	}
 
 	row := us.DB.QueryRow(`
-		SELECT id, password_hash
+		SELECT id, password_hash, email_verified_at IS NOT NULL,
+			totp_enabled_at IS NOT NULL
 		FROM users WHERE email=$1;`, user.Email)
-	err := row.Scan(&user.ID, &user.PasswordHash)
+	err := row.Scan(&user.ID, &user.PasswordHash, &user.EmailVerified,
+		&user.TwoFactorEnabled)
 	if err != nil {
 		return nil, fmt.Errorf("authenticate: %w", err)
 	}
@@ -91,13 +99,23 @@ func (us *UserService) UpdatePassword(userID int, password string) error {
 	return nil
 }
 
+// UpdateEmail changes the user's email address without verifying it. Users
+// changing their own address should go through EmailVerificationService
+// instead. It returns ErrEmailTaken if another user already uses the address.
 func (us *UserService) UpdateEmail(userID int, email string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
 	_, err := us.DB.Exec(`
 		UPDATE users 
-		SET email = $2 
+		SET email = $2, email_verified_at = NULL
 		WHERE id = $1;
 	`, userID, email)
 	if err != nil {
+		var pgError *pgconn.PgError
+		if errors.As(err, &pgError) {
+			if pgError.Code == pgerrcode.UniqueViolation {
+				return ErrEmailTaken
+			}
+		}
 		return fmt.Errorf("update email: %w", err)
 	}
 
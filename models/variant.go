@@ -0,0 +1,161 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	SizeThumb  = "thumb"
+	SizeMedium = "medium"
+	SizeLarge  = "large"
+)
+
+// VariantWidths maps each variant size to the maximum width, in pixels, of
+// the resized images generated for it.
+var VariantWidths = map[string]int{
+	SizeThumb:  320,
+	SizeMedium: 960,
+	SizeLarge:  1920,
+}
+
+// MaxImagePixels is the largest number of pixels an image may have. Decoded
+// images take 4 bytes per pixel, so a small file that declares huge
+// dimensions would otherwise exhaust the memory of the server.
+const MaxImagePixels = 64 * 1000 * 1000
+
+// Variant is a resized copy of an image.
+type Variant struct {
+	Size        string
+	ContentType string
+	// Key is the key the variant is stored under in the Storage.
+	Key string
+}
+
+// Variant describes the variant of the image for the given size. The variant
+// is not guaranteed to exist, e.g. if it has not been generated yet.
+func (s *ImageService) Variant(img *Image, size string) Variant {
+	contentType := "image/png"
+	if img.ContentType == "image/jpeg" {
+		contentType = "image/jpeg"
+	}
+
+	return Variant{
+		Size:        size,
+		ContentType: contentType,
+		Key:         galleryPrefix(img.GalleryID) + size + "/" + img.Filename,
+	}
+}
+
+// CreateVariants generates and stores a resized copy of the image for every
+// size in VariantWidths. Images are never scaled up, so variants of small
+// images keep the original dimensions.
+func (s *ImageService) CreateVariants(img *Image) error {
+	f, err := s.Open(img)
+	if err != nil {
+		return fmt.Errorf("create variants: %w", err)
+	}
+	defer f.Close()
+
+	src, err := decodeImage(f)
+	if err != nil {
+		return fmt.Errorf("create variants: decoding %v: %w", img.Filename, err)
+	}
+
+	for size, width := range VariantWidths {
+		variant := s.Variant(img, size)
+		var buf bytes.Buffer
+		err = encodeVariant(&buf, resize(src, width), variant.ContentType)
+		if err != nil {
+			return fmt.Errorf("create %v variant: %w", size, err)
+		}
+		err = s.storage().Put(variant.Key, &buf, int64(buf.Len()))
+		if err != nil {
+			return fmt.Errorf("create %v variant: %w", size, err)
+		}
+	}
+
+	return nil
+}
+
+// checkDimensions reads the dimensions of the image in r, without decoding
+// it, and returns a FileError if it can't be read or has more than
+// MaxImagePixels pixels. r is rewound to the start before returning.
+func checkDimensions(r io.ReadSeeker) error {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("checking dimensions: %w", err)
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return FileError{
+			Issue: fmt.Sprintf("unreadable image: %v", err),
+			Err:   err,
+		}
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("checking dimensions: %w", err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxImagePixels {
+		return FileError{
+			Issue: fmt.Sprintf("%dx%d pixels is more than %d megapixels",
+				cfg.Width, cfg.Height, MaxImagePixels/1000/1000),
+			Err: ErrTooManyPixels,
+		}
+	}
+
+	return nil
+}
+
+// decodeImage decodes the image in r, unless it has too many pixels to be
+// decoded safely.
+func decodeImage(r io.ReadSeeker) (image.Image, error) {
+	err := checkDimensions(r)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// variantKeys returns the keys of every variant of the image.
+func (s *ImageService) variantKeys(img *Image) []string {
+	keys := make([]string, 0, len(VariantWidths))
+	for size := range VariantWidths {
+		keys = append(keys, s.Variant(img, size).Key)
+	}
+	return keys
+}
+
+// resize scales src down so that it is at most width pixels wide, keeping
+// its aspect ratio.
+func resize(src image.Image, width int) image.Image {
+	bounds := src.Bounds()
+	if bounds.Dx() <= width {
+		return src
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	return dst
+}
+
+func encodeVariant(buf *bytes.Buffer, img image.Image, contentType string) error {
+	if contentType == "image/jpeg" {
+		return jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+	}
+	return png.Encode(buf, img)
+}
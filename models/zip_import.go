@@ -0,0 +1,164 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Limits that protect ImportZip against decompression bombs. Archives that
+// exceed MaxZipEntries or MaxZipTotalSize are rejected as a whole; entries
+// that exceed the other limits are skipped.
+const (
+	MaxZipEntries   = 1000
+	MaxZipEntrySize = 50 << 20 // 50MB
+	MaxZipTotalSize = 1 << 30  // 1GB
+	// MaxZipCompressionRatio is the highest ratio between the uncompressed
+	// and compressed size of an entry. Photos barely compress, so much
+	// higher ratios are a sign of a crafted archive.
+	MaxZipCompressionRatio = 100
+)
+
+// ImportResult describes the outcome of ImportZip.
+type ImportResult struct {
+	Imported []Image
+	Skipped  []SkippedFile
+}
+
+// SkippedFile is an archive entry that was not imported.
+type SkippedFile struct {
+	Name   string
+	Reason string
+}
+
+// ImportZip creates an image in the gallery for every image file in the ZIP
+// archive. Files in folders are imported using their base name. Entries
+// that aren't valid images, are unsafe or are too large are skipped and
+// reported in the result. ErrInvalidArchive is returned if r is not a ZIP
+// archive and ErrArchiveTooLarge if it is too big to import.
+func (s *ImageService) ImportZip(galleryID, userID int, r io.ReaderAt, size int64) (*ImportResult, error) {
+	zr, err := zip.NewReader(r, size)
+	// Depending on GODEBUG, archives with unsafe paths are reported with a
+	// usable reader. Those entries are skipped below.
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return nil, ErrInvalidArchive
+	}
+	if len(zr.File) > MaxZipEntries {
+		return nil, ErrArchiveTooLarge
+	}
+	var total uint64
+	for _, f := range zr.File {
+		total += f.UncompressedSize64
+	}
+	if total > MaxZipTotalSize {
+		return nil, ErrArchiveTooLarge
+	}
+
+	var result ImportResult
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || isArchiveMetadata(f.Name) {
+			continue
+		}
+		skip := func(reason string) {
+			result.Skipped = append(result.Skipped, SkippedFile{
+				Name:   f.Name,
+				Reason: reason,
+			})
+		}
+
+		filename, ok := zipEntryFilename(f.Name)
+		if !ok {
+			skip("unsafe path")
+			continue
+		}
+		if f.UncompressedSize64 > MaxZipEntrySize {
+			skip(fmt.Sprintf("larger than %d MB", MaxZipEntrySize>>20))
+			continue
+		}
+		if f.CompressedSize64 == 0 && f.UncompressedSize64 > 0 ||
+			f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > MaxZipCompressionRatio {
+			skip("suspicious compression ratio")
+			continue
+		}
+		err = checkExtension(filename, s.extensions())
+		if err != nil {
+			skip("not a png, gif or jpg file")
+			continue
+		}
+
+		contents, err := readZipEntry(f)
+		if err != nil {
+			skip("could not be extracted")
+			continue
+		}
+		image, err := s.Create(galleryID, userID, filename, contents)
+		if err != nil {
+			if errors.Is(err, ErrTooManyPixels) {
+				skip(fmt.Sprintf("larger than %d megapixels", MaxImagePixels/1000/1000))
+				continue
+			}
+			var fileErr FileError
+			if errors.As(err, &fileErr) {
+				skip("not a png, gif or jpg file")
+				continue
+			}
+			return &result, fmt.Errorf("import zip: %w", err)
+		}
+		result.Imported = append(result.Imported, *image)
+	}
+
+	return &result, nil
+}
+
+// readZipEntry decompresses the entry into memory. The declared size of an
+// entry can't be trusted, so reading stops after MaxZipEntrySize bytes.
+func readZipEntry(f *zip.File) (*bytes.Reader, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(rc, MaxZipEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxZipEntrySize {
+		return nil, ErrArchiveTooLarge
+	}
+
+	return bytes.NewReader(buf.Bytes()), nil
+}
+
+// zipEntryFilename returns the name an archive entry is imported as. Entries
+// with absolute paths or paths that point outside of the archive are
+// rejected, so that a crafted archive can't write anywhere else.
+func zipEntryFilename(name string) (string, bool) {
+	if strings.Contains(name, "\\") || strings.HasPrefix(name, "/") {
+		return "", false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", false
+		}
+	}
+	filename := path.Base(name)
+	if filename == "." || filename == "/" || strings.HasPrefix(filename, ".") {
+		return "", false
+	}
+
+	return filename, true
+}
+
+// isArchiveMetadata reports whether the entry was added by the operating
+// system that created the archive rather than by the user, such as the
+// resource forks macOS adds.
+func isArchiveMetadata(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), "._") ||
+		path.Base(name) == ".DS_Store" || path.Base(name) == "Thumbs.db"
+}
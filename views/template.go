@@ -41,6 +41,7 @@ func ParseFS(fs fs.FS, patterns ...string) (Template, error) {
 			"errors": func() []string {
 				return nil
 			},
+			"markdown": Markdown,
 		},
 	)
 	tpl, err := tpl.ParseFS(fs, patterns...)